@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+	"gorm.io/gorm"
+
+	"github.com/Skapar/NGE/pkg/nge/database/seed"
+	"github.com/Skapar/NGE/pkg/nge/models"
+)
+
+// ADMINISTRATION
+// _________________________________________________________
+
+// openCurrentDB is openDB for commands that read or change data, which must
+// not run against an outdated schema.
+func openCurrentDB(fs *pflag.FlagSet, args []string) (*gorm.DB, error) {
+	_, db, err := openDB(fs, args)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSchema(db); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// runSeed implements "nge seed". It creates the default roles and the
+// SEED_ADMIN_* administrator, and with --fake or the count flags adds
+// generated data on top.
+func runSeed(args []string) error {
+	fs := pflag.NewFlagSet("seed", pflag.ContinueOnError)
+	preset := fs.String("fake", "", "add generated data: small, medium or large")
+	users := fs.Int("users", -1, "number of generated users, overrides --fake")
+	companies := fs.Int("companies", -1, "number of generated companies, overrides --fake")
+	posts := fs.Int("posts", -1, "number of generated posts, overrides --fake")
+	events := fs.Int("events", -1, "number of generated events, overrides --fake")
+	password := fs.String("fake-password", "password", "password of every generated user")
+	randomSeed := fs.Int64("random-seed", 1, "seed for the generated data, the same seed gives the same data")
+
+	cfg, db, err := openDB(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkSchema(db); err != nil {
+		return err
+	}
+
+	var size seed.Size
+	if *preset != "" {
+		var ok bool
+		if size, ok = seed.Sizes[*preset]; !ok {
+			return fmt.Errorf("--fake must be small, medium or large, not %q", *preset)
+		}
+	}
+	for _, count := range []struct {
+		value  int
+		target *int
+	}{{*users, &size.Users}, {*companies, &size.Companies}, {*posts, &size.Posts}, {*events, &size.Events}} {
+		if count.value >= 0 {
+			*count.target = count.value
+		}
+	}
+
+	created, err := seed.Defaults(db, seed.Admin{
+		Username: cfg.SeedAdminUsername,
+		Email:    cfg.SeedAdminEmail,
+		Password: cfg.SeedAdminPassword,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println("default roles and permissions are in place")
+	if created {
+		fmt.Printf("created administrator <%s>\n", cfg.SeedAdminEmail)
+	}
+
+	if size == (seed.Size{}) {
+		return nil
+	}
+	added, err := seed.Fake(db, seed.FakeOptions{Size: size, Password: *password, Seed: *randomSeed})
+	fmt.Printf("generated %d users, %d companies, %d posts and %d events\n", added.Users, added.Companies, added.Posts, added.Events)
+	return err
+}
+
+// runCreateAdmin implements "nge create-admin". Without --password the
+// password is read from the first line of standard input.
+func runCreateAdmin(args []string) error {
+	fs := pflag.NewFlagSet("create-admin", pflag.ContinueOnError)
+	email := fs.String("email", "", "email address of the administrator (required)")
+	username := fs.String("username", "", "username, defaults to the part of the email before @")
+	password := fs.String("password", "", "password, read from standard input when empty")
+
+	db, err := openCurrentDB(fs, args)
+	if err != nil {
+		return err
+	}
+	if *email == "" {
+		return errors.New("usage: nge create-admin --email EMAIL [--username NAME] [--password PASSWORD]")
+	}
+	if *username == "" {
+		*username, _, _ = strings.Cut(*email, "@")
+	}
+	if *password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return fmt.Errorf("reading password: %w", err)
+		}
+		*password = strings.TrimRight(line, "\r\n")
+	}
+
+	if err := models.SyncRoles(db); err != nil {
+		return err
+	}
+	user, err := models.CreateAdmin(db, *username, *email, *password)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created administrator %d <%s>\n", user.ID, user.Email)
+	return nil
+}
+
+const usersUsage = `usage: nge users <command> [flags]
+
+commands:
+  list              list every user
+  disable ID|EMAIL  stop the user from signing in and revoke their sessions and API keys
+  enable ID|EMAIL   let a disabled user sign in again`
+
+// runUsers implements the "nge users" subcommands.
+func runUsers(args []string) error {
+	if len(args) == 0 {
+		return errors.New(usersUsage)
+	}
+
+	fs := pflag.NewFlagSet("users "+args[0], pflag.ContinueOnError)
+	db, err := openCurrentDB(fs, args[1:])
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		users, err := models.GetUsers(db)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tUSERNAME\tEMAIL\tROLE\tVERIFIED\t2FA\tSTATUS\tCREATED AT")
+		for _, user := range users {
+			status := "active"
+			if user.DisabledAt != nil {
+				status = "disabled"
+			} else if user.LockedUntil != nil && user.LockedUntil.After(time.Now()) {
+				status = "locked"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%t\t%t\t%s\t%s\n", user.ID, user.Username, user.Email, user.Role.Title,
+				user.EmailVerified(), user.TOTPEnabled(), status, user.CreatedAt.Format("2006-01-02 15:04:05"))
+		}
+		return tw.Flush()
+
+	case "disable", "enable":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: nge users %s ID|EMAIL", args[0])
+		}
+		user, err := models.FindUser(db, fs.Arg(0))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("user %s not found", fs.Arg(0))
+		} else if err != nil {
+			return err
+		}
+
+		if args[0] == "disable" {
+			err = models.DisableUser(db, user.ID)
+		} else {
+			err = models.EnableUser(db, user.ID)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%sd user %d <%s>\n", args[0], user.ID, user.Email)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown users command %q\n\n%s", args[0], usersUsage)
+	}
+}
+
+// runRoles implements "nge roles sync", which restores missing permissions
+// and default roles and lists the result.
+func runRoles(args []string) error {
+	if len(args) == 0 || args[0] != "sync" {
+		return errors.New("usage: nge roles sync [flags]")
+	}
+
+	db, err := openCurrentDB(pflag.NewFlagSet("roles sync", pflag.ContinueOnError), args[1:])
+	if err != nil {
+		return err
+	}
+	if err := models.SyncRoles(db); err != nil {
+		return err
+	}
+	roles, err := models.GetRoles(db)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTITLE\tPERMISSIONS")
+	for _, role := range roles {
+		names := make([]string, 0, len(role.Permissions))
+		for _, permission := range role.Permissions {
+			names = append(names, permission.Name)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", role.ID, role.Title, strings.Join(names, ","))
+	}
+	return tw.Flush()
+}
+
+// runTokens implements "nge tokens revoke", which signs one user or every
+// user out, and optionally revokes their API keys too.
+func runTokens(args []string) error {
+	const tokensUsage = "usage: nge tokens revoke (--user ID|EMAIL | --all) [--api-keys]"
+	if len(args) == 0 || args[0] != "revoke" {
+		return errors.New(tokensUsage)
+	}
+
+	fs := pflag.NewFlagSet("tokens revoke", pflag.ContinueOnError)
+	ref := fs.String("user", "", "revoke the tokens of this user")
+	all := fs.Bool("all", false, "revoke the tokens of every user")
+	apiKeys := fs.Bool("api-keys", false, "revoke API keys as well as sessions")
+
+	db, err := openCurrentDB(fs, args[1:])
+	if err != nil {
+		return err
+	}
+	if (*ref == "") == !*all {
+		return errors.New(tokensUsage)
+	}
+
+	var userID uint
+	if *ref != "" {
+		user, err := models.FindUser(db, *ref)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("user %s not found", *ref)
+		} else if err != nil {
+			return err
+		}
+		userID = user.ID
+		if err := models.RevokeUserSessions(db, userID); err != nil {
+			return err
+		}
+		fmt.Printf("revoked the sessions of user %d <%s>\n", user.ID, user.Email)
+	} else {
+		count, err := models.RevokeAllSessions(db)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("revoked %d sessions\n", count)
+	}
+
+	if *apiKeys {
+		count, err := models.RevokeAPIKeys(db, userID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("revoked %d API keys\n", count)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/Skapar/NGE/pkg/nge/models"
+)
+
+// API KEYS
+// _________________________________________________________
+
+// scopeAllowed reports whether an API key used for the request may use
+// scope. Requests signed in with a JWT, and keys without scopes, are not
+// restricted.
+func scopeAllowed(r *http.Request, scope string) bool {
+	scopes, ok := r.Context().Value("apiKeyScopes").([]string)
+	if !ok || len(scopes) == 0 {
+		return true
+	}
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+var errSessionRequired = models.Forbidden("session_required", "This endpoint cannot be used with an API key")
+
+// RequireSession rejects requests authenticated with an API key, for
+// endpoints that manage credentials and must not be reachable by scripts.
+// It must be wrapped by AuthMiddleware.
+func (app *App) RequireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value("sessionID").(uint); !ok {
+			app.writeError(w, r, errSessionRequired)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+func (app *App) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+	if input.Name == "" {
+		app.writeError(w, r, models.InvalidField("name", "is required"))
+		return
+	}
+	if input.ExpiresAt != nil && input.ExpiresAt.Before(time.Now()) {
+		app.writeError(w, r, models.InvalidField("expires_at", "must be in the future"))
+		return
+	}
+
+	userID, _ := r.Context().Value("userID").(uint)
+
+	created, err := models.CreateAPIKey(app.db(r), userID, input.Name, input.Scopes, input.ExpiresAt)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusCreated, created)
+}
+
+func (app *App) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("userID").(uint)
+
+	keys, err := models.GetAPIKeys(app.db(r), userID)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	response := make([]models.APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		response = append(response, key.Response())
+	}
+
+	writeJSONResponse(w, http.StatusOK, response)
+}
+
+func (app *App) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("userID").(uint)
+
+	keyID, err := routeID(r, "id", "API key")
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	if err := models.RevokeAPIKey(app.db(r), userID, keyID); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "API key revoked successfully"})
+}
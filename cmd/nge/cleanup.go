@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/Skapar/NGE/pkg/nge/models"
+)
+
+// CLEANUP
+// _________________________________________________________
+
+// cleanupExpired deletes expired sessions, tokens and old login attempts
+// every interval until
+// ctx is cancelled. A purge in progress is finished before it returns.
+func (app *App) cleanupExpired(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deleted, err := models.PurgeExpired(app.DB, now, app.Config.LoginAttemptRetention)
+			if err != nil {
+				app.Logger.Error("deleting expired sessions and tokens", "error", err)
+			} else if deleted > 0 {
+				app.Logger.Info("deleted expired sessions and tokens", "count", deleted)
+			}
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Skapar/NGE/pkg/nge/models"
+)
+
+// COMPANY MEMBERS
+// _________________________________________________________
+
+// RequireCompanyRole lets the request through when the signed-in user has at
+// least minRole in the company named by the {id} route variable and may write
+// companies, or when they may moderate companies, which counts as owner. The
+// effective role is stored in the request context as "companyRole". It must
+// be wrapped by AuthMiddleware.
+func (app *App) RequireCompanyRole(minRole string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		companyID, err := routeID(r, "id", "company")
+		if err != nil {
+			app.writeError(w, r, err)
+			return
+		}
+
+		userID, _ := r.Context().Value("userID").(uint)
+		role, err := models.GetCompanyRole(app.db(r), companyID, userID)
+		if err != nil {
+			app.writeError(w, r, err)
+			return
+		}
+
+		allowed := false
+		if models.CompanyRoleAtLeast(role, minRole) {
+			allowed, err = app.hasPermission(r, models.PermCompaniesWrite)
+		}
+		if err == nil && !allowed {
+			allowed, err = app.hasPermission(r, models.PermCompaniesModerate)
+			if allowed {
+				role = models.CompanyRoleOwner
+			}
+		}
+		if err != nil {
+			app.writeError(w, r, err)
+			return
+		}
+		if !allowed {
+			app.writeError(w, r, models.Forbidden("company_role_required", "This requires the "+minRole+" role in the company"))
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), "companyRole", role)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// canManageCompanyRole reports whether a member with role actor may grant,
+// change or take away role. Owners manage everyone; other members only manage
+// roles below their own.
+func canManageCompanyRole(actor, role string) bool {
+	if actor == models.CompanyRoleOwner {
+		return true
+	}
+	return models.CompanyRoleAtLeast(actor, role) && actor != role
+}
+
+// companyMemberVars returns the {id} and {userID} route variables.
+func companyMemberVars(r *http.Request) (uint, uint, error) {
+	companyID, err := routeID(r, "id", "company")
+	if err != nil {
+		return 0, 0, err
+	}
+	memberID, err := routeID(r, "userID", "user")
+	if err != nil {
+		return 0, 0, err
+	}
+	return companyID, memberID, nil
+}
+
+func (app *App) ListCompanyMembersHandler(w http.ResponseWriter, r *http.Request) {
+	companyID, _ := routeID(r, "id", "company")
+
+	members, err := models.GetCompanyMembers(app.db(r), companyID)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, members)
+}
+
+func (app *App) AddCompanyMemberHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserID uint   `json:"user_id"`
+		Role   string `json:"role"`
+	}
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+	if !models.IsCompanyRole(input.Role) {
+		app.writeError(w, r, models.ErrInvalidCompanyRole)
+		return
+	}
+
+	actor, _ := r.Context().Value("companyRole").(string)
+	if !canManageCompanyRole(actor, input.Role) {
+		app.writeError(w, r, models.Forbidden("company_role_required", "You cannot add members with the "+input.Role+" role"))
+		return
+	}
+
+	companyID, _ := routeID(r, "id", "company")
+	member, err := models.AddCompanyMember(app.db(r), companyID, input.UserID, input.Role)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusCreated, member)
+}
+
+func (app *App) UpdateCompanyMemberHandler(w http.ResponseWriter, r *http.Request) {
+	companyID, memberID, err := companyMemberVars(r)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	var input struct {
+		Role string `json:"role"`
+	}
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+	if !models.IsCompanyRole(input.Role) {
+		app.writeError(w, r, models.ErrInvalidCompanyRole)
+		return
+	}
+
+	current, err := models.GetCompanyRole(app.db(r), companyID, memberID)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+	if current == "" {
+		app.writeError(w, r, models.ErrNotMember)
+		return
+	}
+
+	actor, _ := r.Context().Value("companyRole").(string)
+	if !canManageCompanyRole(actor, current) || !canManageCompanyRole(actor, input.Role) {
+		app.writeError(w, r, models.Forbidden("company_role_required", "You cannot change the role of this member"))
+		return
+	}
+
+	member, err := models.UpdateCompanyMemberRole(app.db(r), companyID, memberID, input.Role)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, member)
+}
+
+// RemoveCompanyMemberHandler removes a member. Any member may remove
+// themselves to leave the company.
+func (app *App) RemoveCompanyMemberHandler(w http.ResponseWriter, r *http.Request) {
+	companyID, memberID, err := companyMemberVars(r)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	userID, _ := r.Context().Value("userID").(uint)
+	if memberID != userID {
+		current, err := models.GetCompanyRole(app.db(r), companyID, memberID)
+		if err != nil {
+			app.writeError(w, r, err)
+			return
+		}
+		if current == "" {
+			app.writeError(w, r, models.ErrNotMember)
+			return
+		}
+
+		actor, _ := r.Context().Value("companyRole").(string)
+		if !models.CompanyRoleAtLeast(actor, models.CompanyRoleAdmin) || !canManageCompanyRole(actor, current) {
+			app.writeError(w, r, models.Forbidden("company_role_required", "You cannot remove this member"))
+			return
+		}
+	}
+
+	if err := models.RemoveCompanyMember(app.db(r), companyID, memberID); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Member removed successfully"})
+}
+
+func (app *App) TransferCompanyOwnershipHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		UserID uint `json:"user_id"`
+	}
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	companyID, _ := routeID(r, "id", "company")
+	userID, _ := r.Context().Value("userID").(uint)
+
+	if err := models.TransferCompanyOwnership(app.db(r), companyID, userID, input.UserID); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Ownership transferred successfully"})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/pflag"
+	"gorm.io/gorm"
+
+	config "github.com/Skapar/NGE/pkg/nge/config"
+	initializers "github.com/Skapar/NGE/pkg/nge/database/initializers"
+	"github.com/Skapar/NGE/pkg/nge/models"
+)
+
+// CONFIGURATION
+// _________________________________________________________
+
+// parseConfig adds the configuration flags to fs, parses args and loads the
+// configuration without validating it.
+func parseConfig(fs *pflag.FlagSet, args []string) (initializers.Config, error) {
+	configPath := initializers.BindFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return initializers.Config{}, err
+	}
+
+	cfg, err := initializers.LoadConfig(*configPath)
+	if err != nil {
+		return cfg, fmt.Errorf("loading configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// loadConfig is parseConfig followed by validation, for commands that use
+// the configuration.
+func loadConfig(fs *pflag.FlagSet, args []string) (initializers.Config, error) {
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		return cfg, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// openDB loads and validates the configuration and connects to the
+// database. Every command that needs the database goes through it.
+func openDB(fs *pflag.FlagSet, args []string) (initializers.Config, *gorm.DB, error) {
+	cfg, err := loadConfig(fs, args)
+	if err != nil {
+		return cfg, nil, err
+	}
+	if err := models.SetBcryptCost(cfg.BcryptCost); err != nil {
+		return cfg, nil, fmt.Errorf("invalid BCRYPT_COST: %w", err)
+	}
+
+	db, err := config.Connect(&cfg)
+	if err != nil {
+		return cfg, nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return cfg, db, nil
+}
+
+// runConfig implements "nge config print", which shows the effective
+// configuration with secrets redacted and then reports any problem with it.
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return errors.New("usage: nge config print [flags]")
+	}
+
+	cfg, err := parseConfig(pflag.NewFlagSet("config print", pflag.ContinueOnError), args[1:])
+	if err != nil {
+		return err
+	}
+	if err := cfg.WriteRedacted(os.Stdout); err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration:\n%w", err)
+	}
+	return nil
+}
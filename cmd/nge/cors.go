@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// CORS
+// _________________________________________________________
+
+// CORS lets the browsers of the origins in CORS_ALLOWED_ORIGINS call the
+// API. It answers preflight requests itself, for every route of router,
+// allowing the methods the route is registered with and CORS_ALLOWED_METHODS
+// permits. Other requests from an allowed origin get the
+// Access-Control-Allow-Origin header. It wraps the router, since preflight
+// requests use OPTIONS, which no route is registered with.
+func (app *App) CORS(router *mux.Router) http.Handler {
+	allowedHeaders := map[string]bool{}
+	for _, header := range app.Config.CORSAllowedHeaders {
+		allowedHeaders[strings.ToLower(header)] = true
+	}
+	exposedHeaders := strings.Join(app.Config.CORSExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(app.Config.CORSMaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			router.ServeHTTP(w, r)
+			return
+		}
+		allowed := originAllowed(app.Config.CORSAllowedOrigins, origin)
+
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method != http.MethodOptions || requestedMethod == "" {
+			w.Header().Add("Vary", "Origin")
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				if app.Config.CORSAllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+				if exposedHeaders != "" {
+					w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+				}
+			}
+			router.ServeHTTP(w, r)
+			return
+		}
+
+		methods, route := routeMethods(router, r, app.Config.CORSAllowedMethods)
+		if len(methods) == 0 {
+			// Not a route of the API, the router answers 404.
+			router.ServeHTTP(w, r)
+			return
+		}
+		if entry, ok := r.Context().Value("requestLog").(*requestLog); ok {
+			entry.Route = route
+		}
+
+		// Leaving out the Access-Control-Allow-* headers is how a preflight
+		// request is refused; the browser then blocks the actual request.
+		w.Header().Add("Vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+		requestedHeaders := r.Header.Get("Access-Control-Request-Headers")
+		if allowed && slices.Contains(methods, requestedMethod) && headersAllowed(allowedHeaders, requestedHeaders) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			if requestedHeaders != "" {
+				w.Header().Set("Access-Control-Allow-Headers", requestedHeaders)
+			}
+			if app.Config.CORSAllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+			if app.Config.CORSMaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// originAllowed matches origin against patterns such as
+// https://app.example.com, https://*.example.com, where * stands for one or
+// more subdomains, or * for any origin.
+func originAllowed(patterns []string, origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "/"))
+		if pattern == "*" || pattern == origin {
+			return true
+		}
+		prefix, suffix, ok := strings.Cut(pattern, "*")
+		if !ok || len(origin) <= len(prefix)+len(suffix) ||
+			!strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+			continue
+		}
+		// The wildcard must not swallow the scheme, a port or a path.
+		if middle := origin[len(prefix) : len(origin)-len(suffix)]; !strings.ContainsAny(middle, "/:") {
+			return true
+		}
+	}
+	return false
+}
+
+// routeMethods returns which of methods the route matching the path of r is
+// registered with, and the route template.
+func routeMethods(router *mux.Router, r *http.Request, methods []string) ([]string, string) {
+	var matched []string
+	var template string
+	for _, method := range methods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.MatchErr == nil {
+			matched = append(matched, method)
+			template, _ = match.Route.GetPathTemplate()
+		}
+	}
+	return matched, template
+}
+
+// headersAllowed reports whether every header of the comma separated
+// Access-Control-Request-Headers list is allowed.
+func headersAllowed(allowed map[string]bool, requested string) bool {
+	if allowed["*"] {
+		return true
+	}
+	for _, header := range strings.Split(requested, ",") {
+		if header = strings.ToLower(strings.TrimSpace(header)); header != "" && !allowed[header] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+package main
+
+import "testing"
+
+func TestOriginAllowed(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		origin   string
+		want     bool
+	}{
+		{nil, "https://app.example.com", false},
+		{[]string{"*"}, "https://anything.test", true},
+		{[]string{"https://app.example.com"}, "https://app.example.com", true},
+		{[]string{"https://app.example.com/"}, "https://app.example.com", true},
+		{[]string{"https://app.example.com"}, "HTTPS://App.Example.com", true},
+		{[]string{"https://app.example.com"}, "http://app.example.com", false},
+		{[]string{"https://app.example.com"}, "https://app.example.com:8443", false},
+		{[]string{"https://*.example.com"}, "https://app.example.com", true},
+		{[]string{"https://*.example.com"}, "https://a.b.example.com", true},
+		{[]string{"https://*.example.com"}, "https://example.com", false},
+		{[]string{"https://*.example.com"}, "https://.example.com", false},
+		{[]string{"https://*.example.com"}, "https://evil.com/.example.com", false},
+		{[]string{"https://*.example.com"}, "https://evil.com:1.example.com", false},
+		{[]string{"https://*.example.com"}, "https://app.example.com.evil.com", false},
+		{[]string{"https://*.example.com"}, "http://app.example.com", false},
+		{[]string{"https://other.test", "https://*.example.com"}, "https://app.example.com", true},
+	}
+	for _, tt := range tests {
+		if got := originAllowed(tt.patterns, tt.origin); got != tt.want {
+			t.Errorf("originAllowed(%q, %q) = %v, want %v", tt.patterns, tt.origin, got, tt.want)
+		}
+	}
+}
+
+func TestHeadersAllowed(t *testing.T) {
+	allowed := map[string]bool{"authorization": true, "content-type": true}
+	tests := []struct {
+		allowed   map[string]bool
+		requested string
+		want      bool
+	}{
+		{allowed, "", true},
+		{allowed, "Authorization", true},
+		{allowed, "authorization, Content-Type", true},
+		{allowed, " content-type ,, authorization ", true},
+		{allowed, "authorization, x-api-key", false},
+		{allowed, "x-api-key", false},
+		{map[string]bool{}, "authorization", false},
+		{map[string]bool{"*": true}, "x-api-key, x-anything", true},
+	}
+	for _, tt := range tests {
+		if got := headersAllowed(tt.allowed, tt.requested); got != tt.want {
+			t.Errorf("headersAllowed(%v, %q) = %v, want %v", tt.allowed, tt.requested, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"gorm.io/gorm"
+
+	"github.com/Skapar/NGE/pkg/nge/models"
+)
+
+// ERRORS
+// _________________________________________________________
+
+// ErrorResponse is the body of every error response.
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+// ErrorBody describes what went wrong. Code is stable and meant for
+// programs, Message for people. Fields holds a message per invalid input
+// field, and RequestID matches the X-Request-ID header and the server logs.
+type ErrorBody struct {
+	Code      string            `json:"code"`
+	Message   string            `json:"message"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	RequestID string            `json:"request_id,omitempty"`
+}
+
+// newErrorBody returns a body with the ID of the request, when it has one.
+func newErrorBody(r *http.Request, code, message string) ErrorBody {
+	body := ErrorBody{Code: code, Message: message}
+	if requestID, ok := r.Context().Value("requestID").(string); ok {
+		body.RequestID = requestID
+	}
+	return body
+}
+
+// errorStatus is the status code of each kind of domain error.
+var errorStatus = map[models.Kind]int{
+	models.KindValidation:      http.StatusBadRequest,
+	models.KindUnauthorized:    http.StatusUnauthorized,
+	models.KindForbidden:       http.StatusForbidden,
+	models.KindNotFound:        http.StatusNotFound,
+	models.KindConflict:        http.StatusConflict,
+	models.KindTooManyRequests: http.StatusTooManyRequests,
+	models.KindUpstream:        http.StatusBadGateway,
+}
+
+// writeError answers the request with err. Domain errors from models get
+// the status of their kind and are reported as they are; a bare
+// gorm.ErrRecordNotFound is a 404. Anything else is logged and answered
+// with a generic 500, so that internal details do not reach clients.
+func (app *App) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status := http.StatusInternalServerError
+	body := newErrorBody(r, "internal_error", "internal server error")
+
+	var domainErr *models.Error
+	switch {
+	case errors.As(err, &domainErr):
+		if s, ok := errorStatus[domainErr.Kind]; ok {
+			status = s
+		}
+		body.Code = domainErr.Code
+		// The message of err includes the context it was wrapped with, such
+		// as the name of an unknown scope.
+		body.Message = err.Error()
+		body.Fields = domainErr.Fields
+		if domainErr.RetryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(seconds(domainErr.RetryAfter)))
+		}
+		if status >= http.StatusInternalServerError {
+			app.logger(r).Error("request failed", "error", err, "cause", domainErr.Err)
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		status = http.StatusNotFound
+		body.Code = "not_found"
+		body.Message = "not found"
+	default:
+		app.logger(r).Error("request failed", "error", err)
+	}
+
+	writeJSONResponse(w, status, ErrorResponse{body})
+}
+
+var errRouteNotFound = models.NotFound("route_not_found", "no route matches the requested path")
+
+// notFoundHandler answers requests that match no route.
+func (app *App) notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	app.writeError(w, r, errRouteNotFound)
+}
+
+// methodNotAllowedHandler answers requests to a route that is not
+// registered with their method.
+func (app *App) methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	body := newErrorBody(r, "method_not_allowed", "the route does not support this method")
+	writeJSONResponse(w, http.StatusMethodNotAllowed, ErrorResponse{body})
+}
+
+// decodeJSON reads the JSON request body into v.
+func decodeJSON(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return models.Invalid("invalid_body", "request body is not valid JSON: "+err.Error())
+	}
+	return nil
+}
+
+// routeID parses the route variable name, such as the {id} of /post/{id},
+// naming the resource in the error.
+func routeID(r *http.Request, name, resource string) (uint, error) {
+	id, err := strconv.ParseUint(mux.Vars(r)[name], 10, 64)
+	if err != nil {
+		return 0, models.Invalid("invalid_id", "invalid "+resource+" ID").WithField(name, "must be a positive integer")
+	}
+	return uint(id), nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"gorm.io/gorm"
+
+	"github.com/Skapar/NGE/pkg/nge/models"
+)
+
+// EXPORT
+// _________________________________________________________
+
+// exporters load one kind of record for "nge export". Users go through
+// Response so credentials are never exported.
+var exporters = map[string]func(db *gorm.DB) (interface{}, error){
+	"users": func(db *gorm.DB) (interface{}, error) {
+		users, err := models.GetUsers(db)
+		if err != nil {
+			return nil, err
+		}
+		responses := make([]models.UserResponse, 0, len(users))
+		for _, user := range users {
+			responses = append(responses, user.Response())
+		}
+		return responses, nil
+	},
+	"roles": func(db *gorm.DB) (interface{}, error) {
+		return models.GetRoles(db)
+	},
+	"companies": func(db *gorm.DB) (interface{}, error) {
+		return models.GetAllCompanies(db)
+	},
+	"posts": func(db *gorm.DB) (interface{}, error) {
+		return models.GetAllPosts(db)
+	},
+	"events": func(db *gorm.DB) (interface{}, error) {
+		return models.GetAllEvents(db, 0)
+	},
+}
+
+// runExport implements "nge export", which writes the requested kinds of
+// records, or all of them, as one JSON object keyed by kind.
+func runExport(args []string) error {
+	kinds := make([]string, 0, len(exporters))
+	for kind := range exporters {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	fs := pflag.NewFlagSet("export", pflag.ContinueOnError)
+	output := fs.StringP("output", "o", "", "file to write to instead of standard output")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: nge export [flags] [%s]...\n", strings.Join(kinds, "|"))
+		fs.PrintDefaults()
+	}
+
+	db, err := openCurrentDB(fs, args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		kinds = fs.Args()
+	}
+
+	data := make(map[string]interface{}, len(kinds))
+	for _, kind := range kinds {
+		export, ok := exporters[kind]
+		if !ok {
+			return fmt.Errorf("cannot export %q", kind)
+		}
+		if data[kind], err = export(db); err != nil {
+			return fmt.Errorf("exporting %s: %w", kind, err)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
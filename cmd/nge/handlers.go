@@ -5,16 +5,16 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
-	"strconv"
+	"net/netip"
+	"net/url"
 	"strings"
 	"time"
 
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 
 	NGE "github.com/Skapar/NGE/pkg/nge"
-	"github.com/gorilla/mux"
 
 	"github.com/Skapar/NGE/pkg/nge/models"
 )
@@ -24,10 +24,6 @@ type HealthCheckResponse struct {
 	Check  string `json:"Check"`
 }
 
-type ErrorResponse struct {
-	Error string `json:"error"`
-}
-
 type CustomDB struct {
 	*gorm.DB
 }
@@ -40,8 +36,9 @@ type EventRequest struct {
 func writeJSONResponse(w http.ResponseWriter, code int, payload interface{}) {
 	response, err := json.Marshal(payload)
 	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{"Internal Server Error"})
+		json.NewEncoder(w).Encode(ErrorResponse{ErrorBody{Code: "internal_error", Message: "internal server error"}})
 		return
 	}
 
@@ -49,61 +46,134 @@ func writeJSONResponse(w http.ResponseWriter, code int, payload interface{}) {
 	w.WriteHeader(code)
 	w.Write(response)
 }
-func extractUserIDFromToken(r *http.Request) (uint, error) {
-	authHeader := r.Header.Get("Authorization")
-	if authHeader == "" {
-		return 0, fmt.Errorf("no Authorization token provided")
+
+// clientIP returns the address of the client that sent the request. When
+// the peer is one of TRUSTED_PROXIES, it is the right-most address of
+// X-Forwarded-For that is not a trusted proxy, since anything left of it may
+// have been made up by the client.
+func (app *App) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !app.trustedProxy(peer) {
+		return host
 	}
 
-	parts := strings.Split(authHeader, " ")
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		return 0, fmt.Errorf("authorization header format must be Bearer {token}")
+	client := peer
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap()
+		if !app.trustedProxy(client) {
+			break
+		}
 	}
+	return client.String()
+}
 
-	tokenStr := parts[1]
+func (app *App) trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range app.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
 
-	claims, err := models.ValidateToken(tokenStr)
-	if err != nil {
-		return 0, err
+func (app *App) loginPolicy() models.LoginPolicy {
+	return models.LoginPolicy{
+		BackoffThreshold:   app.Config.LoginBackoffThreshold,
+		BackoffBase:        app.Config.LoginBackoffBase,
+		BackoffMax:         app.Config.LoginBackoffMax,
+		MaxFailures:        app.Config.LoginMaxFailures,
+		LockoutDuration:    app.Config.LoginLockoutDuration,
+		IPBackoffThreshold: app.Config.LoginIPBackoffThreshold,
+		IPWindow:           app.Config.LoginIPWindow,
 	}
+}
+
+// currentUser loads the user authenticated by AuthMiddleware.
+func (app *App) currentUser(r *http.Request) (*models.User, error) {
+	userID, ok := r.Context().Value("userID").(uint)
+	if !ok {
+		return nil, fmt.Errorf("request is not authenticated")
+	}
+
+	var user models.User
+	if err := app.db(r).First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// goBackground runs fn after the response, with the values of the request
+// context, such as the logger fields and the trace, but not its
+// cancellation. The server waits for it before closing the database.
+func (app *App) goBackground(r *http.Request, fn func(ctx context.Context, db *gorm.DB)) {
+	ctx := context.WithoutCancel(r.Context())
+	app.background.Add(1)
+	go func() {
+		defer app.background.Done()
+		fn(ctx, app.DB.WithContext(ctx))
+	}()
+}
 
-	return claims.UserID, nil
+// clientLink returns a link to a page of the web frontend at CLIENT_ORIGIN,
+// such as the form that accepts an invitation, carrying token. The API only
+// answers those routes with POST, so without a frontend the links fall back
+// to APP_BASE_URL for a client that handles them there.
+func (app *App) clientLink(path, token string) string {
+	base := app.Config.ClientOrigin
+	if base == "" {
+		base = app.Config.AppBaseURL
+	}
+	return fmt.Sprintf("%s%s?token=%s", strings.TrimRight(base, "/"), path, url.QueryEscape(token))
 }
 
 func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	writeJSONResponse(w, http.StatusOK, HealthCheckResponse{"ok", NGE.HealthCheck()})
 }
 
+func jwksHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	writeJSONResponse(w, http.StatusOK, models.PublicJWKS())
+}
+
 // Events CRUD
 // _____________________________________________________
 func (app *App) AddEventHandler(w http.ResponseWriter, r *http.Request) {
 	var req EventRequest
-
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeJSON(r, &req); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
 
-	err = models.AddEvent(app.DB, req.Date, req.Description)
-	if err != nil {
-		http.Error(w, "Failed to add event", http.StatusInternalServerError)
+	userID, _ := r.Context().Value("userID").(uint)
+
+	if err := models.AddEvent(app.db(r), userID, req.Date, req.Description); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
+	app.Metrics.EventsCreated.Inc()
 
 	writeJSONResponse(w, http.StatusCreated, map[string]string{"result": "Event added successfully"})
 }
 
 func (app *App) DeleteEventHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	id, err := routeID(r, "id", "event")
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		app.writeError(w, r, err)
 		return
 	}
 
-	if err := models.DeleteEvent(app.DB, uint(id)); err != nil {
-		http.Error(w, "Event not found", http.StatusNotFound)
+	if err := models.DeleteEvent(app.db(r), id); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
 
@@ -111,21 +181,20 @@ func (app *App) DeleteEventHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *App) UpdateEventHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	id, err := routeID(r, "id", "event")
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		app.writeError(w, r, err)
 		return
 	}
 
 	var req EventRequest // Use the EventRequest struct for decoding request body
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeJSON(r, &req); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
 
-	if err := models.UpdateEvent(app.DB, uint(id), req.Date, req.Description); err != nil {
-		http.Error(w, "Failed to update event", http.StatusInternalServerError)
+	if err := models.UpdateEvent(app.db(r), id, req.Date, req.Description); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
 
@@ -133,16 +202,15 @@ func (app *App) UpdateEventHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *App) GetEventHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	id, err := routeID(r, "id", "event")
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		app.writeError(w, r, err)
 		return
 	}
 
-	event, err := models.GetEventByID(app.DB, uint(id))
+	event, err := models.GetEventByID(app.db(r), id)
 	if err != nil {
-		http.Error(w, "Event not found", http.StatusNotFound)
+		app.writeError(w, r, err)
 		return
 	}
 
@@ -154,53 +222,50 @@ func (app *App) GetEventHandler(w http.ResponseWriter, r *http.Request) {
 // POSTS HANDLER
 
 func (app *App) addPost(w http.ResponseWriter, r *http.Request) {
-	var newPost models.Post
-	if err := json.NewDecoder(r.Body).Decode(&newPost); err != nil {
-		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{err.Error()})
+	var input struct {
+		Text string `json:"text"`
+	}
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
 
-	createdPost, err := models.AddPost(app.DB, newPost)
+	userID, _ := r.Context().Value("userID").(uint)
+	newPost := models.Post{Text: input.Text, AuthorID: userID}
+
+	createdPost, err := models.AddPost(app.db(r), newPost)
 	if err != nil {
-		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		app.writeError(w, r, err)
 		return
 	}
+	app.Metrics.PostsCreated.Inc()
 
 	writeJSONResponse(w, http.StatusCreated, createdPost)
 }
 
 func (app *App) updatePostById(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	postID, err := strconv.Atoi(vars["id"])
+	postID, err := routeID(r, "id", "post")
 	if err != nil {
-		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		app.writeError(w, r, err)
 		return
 	}
 
 	var updatedPost models.Post
-	if err := json.NewDecoder(r.Body).Decode(&updatedPost); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeJSON(r, &updatedPost); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
 
-	var existingPost models.Post
-	if err := app.DB.First(&existingPost, uint(postID)).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			http.Error(w, "Post not found", http.StatusNotFound)
-		} else {
-			http.Error(w, "Failed to fetch post", http.StatusInternalServerError)
-		}
+	existingPost, err := models.GetPost(app.db(r), postID)
+	if err != nil {
+		app.writeError(w, r, err)
 		return
 	}
 
 	existingPost.Text = updatedPost.Text
 
-	if err := app.DB.Save(&existingPost).Error; err != nil {
-		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
-			http.Error(w, "Duplicate post text", http.StatusConflict)
-			return
-		}
-		http.Error(w, "Failed to update post", http.StatusInternalServerError)
+	if _, err := models.UpdatePost(app.db(r), existingPost); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
 
@@ -208,16 +273,14 @@ func (app *App) updatePostById(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *App) deletePostById(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	postID, err := strconv.ParseUint(vars["id"], 10, 64)
+	postID, err := routeID(r, "id", "post")
 	if err != nil {
-		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{"Invalid post ID"})
+		app.writeError(w, r, err)
 		return
 	}
 
-	err = models.DeletePost(app.DB, uint(postID)) // pass app.DB to the function
-	if err != nil {
-		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+	if err := models.DeletePost(app.db(r), postID); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
 
@@ -225,16 +288,15 @@ func (app *App) deletePostById(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *App) getPostById(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	postID, err := strconv.ParseUint(vars["id"], 10, 64)
+	postID, err := routeID(r, "id", "post")
 	if err != nil {
-		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{"Invalid post ID"})
+		app.writeError(w, r, err)
 		return
 	}
 
-	post, err := models.GetPost(app.DB, uint(postID))
+	post, err := models.GetPost(app.db(r), postID)
 	if err != nil {
-		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		app.writeError(w, r, err)
 		return
 	}
 
@@ -242,106 +304,80 @@ func (app *App) getPostById(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *App) getAllPosts(w http.ResponseWriter, r *http.Request) {
-	posts, err := models.GetAllPosts(app.DB)
+	posts, err := models.GetAllPosts(app.db(r))
 	if err != nil {
-		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		app.writeError(w, r, err)
 		return
 	}
 
 	writeJSONResponse(w, http.StatusOK, posts)
 }
 
-// _____________________________________________________________
-// Role
-
-func (app *App) CreateRoleHandler(w http.ResponseWriter, r *http.Request) {
-	var newRole models.Role
-	if err := json.NewDecoder(r.Body).Decode(&newRole); err != nil {
-		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{err.Error()})
-		return
-	}
-
-	createdRole, err := models.AddRole(app.DB, newRole)
-	if err != nil {
-		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
-		return
-	}
-
-	writeJSONResponse(w, http.StatusCreated, createdRole)
-}
-
 // User's handler
 
 func (app *App) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	userID, err := strconv.ParseUint(vars["id"], 10, 64)
+	userID, err := routeID(r, "id", "user")
 	if err != nil {
-		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{"Invalid user ID"})
+		app.writeError(w, r, err)
 		return
 	}
 
 	var updatedUser models.User
-	if err := json.NewDecoder(r.Body).Decode(&updatedUser); err != nil {
-		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{err.Error()})
+	if err := decodeJSON(r, &updatedUser); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
 
-	updatedUser.ID = uint(userID)
-	updatedUser, err = models.UpdateUser(app.DB, updatedUser)
+	updatedUser.ID = userID
+	updatedUser, err = models.UpdateUser(app.db(r), updatedUser)
 	if err != nil {
-		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		app.writeError(w, r, err)
 		return
 	}
 
-	writeJSONResponse(w, http.StatusOK, updatedUser)
+	writeJSONResponse(w, http.StatusOK, updatedUser.Response())
 }
 
 func (app *App) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
-	userID, err := extractUserIDFromToken(r)
-	// fmt.Println(userID)
+	userIDToDelete, err := routeID(r, "id", "user")
 	if err != nil {
-		writeJSONResponse(w, http.StatusUnauthorized, ErrorResponse{"Invalid or missing token"})
+		app.writeError(w, r, err)
 		return
 	}
 
-	role, err := models.GetUserRole(app.DB, userID)
-	if err != nil {
-		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
-		return
-	}
-	fmt.Println(role)
-	if role != 2 {
-		writeJSONResponse(w, http.StatusForbidden, ErrorResponse{"Insufficient permissions"})
+	if err := models.DeleteUser(app.db(r), userIDToDelete); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
 
-	vars := mux.Vars(r)
-	userIDToDelete, err := strconv.ParseUint(vars["id"], 10, 64)
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "User deleted successfully"})
+}
+
+func (app *App) UnlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	userIDToUnlock, err := routeID(r, "id", "user")
 	if err != nil {
-		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{"Invalid user ID"})
+		app.writeError(w, r, err)
 		return
 	}
 
-	err = models.DeleteUser(app.DB, uint(userIDToDelete))
-	if err != nil {
-		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+	if err := models.UnlockUser(app.db(r), userIDToUnlock); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
 
-	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "User deleted successfully"})
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "User unlocked successfully"})
 }
 
 // func (app *App) GetUserHandler(w http.ResponseWriter, r *http.Request) {
-// 	vars := mux.Vars(r)
-// 	userID, err := strconv.ParseUint(vars["id"], 10, 64)
+// 	userID, err := routeID(r, "id", "user")
 // 	if err != nil {
-// 		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{"Invalid user ID"})
+// 		app.writeError(w, r, err)
 // 		return
 // 	}
 
-// 	user, err := models.GetUserByID(app.DB, uint(userID))
+// 	user, err := models.GetUserByID(app.db(r), userID)
 // 	if err != nil {
-// 		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+// 		app.writeError(w, r, err)
 // 		return
 // 	}
 
@@ -356,20 +392,33 @@ func (app *App) SignUpHandler(w http.ResponseWriter, r *http.Request) {
 		Username string `json:"username"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
-		Role     int    `json:"role_id"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{err.Error()})
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
-	createdUser, err := models.Signup(app.DB, input.Username, input.Email, input.Password, input.Role)
+	createdUser, err := models.Signup(app.db(r), input.Username, input.Email, input.Password)
 	if err != nil {
-		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		app.writeError(w, r, err)
 		return
 	}
 
-	writeJSONResponse(w, http.StatusCreated, createdUser)
+	app.Metrics.Signups.Inc()
+
+	// The account exists at this point; a failed email only means the user
+	// has to ask for another one through /verify-email/resend.
+	logger := app.logger(r)
+	user := *createdUser
+	app.goBackground(r, func(ctx context.Context, db *gorm.DB) {
+		if err := models.MarkVerificationSent(db, user, 0); err != nil {
+			logger.Error("recording verification email", "user_id", user.ID, "error", err)
+		} else if err := app.sendVerificationEmail(ctx, user); err != nil {
+			logger.Error("sending verification email", "user_id", user.ID, "error", err)
+		}
+	})
+
+	writeJSONResponse(w, http.StatusCreated, createdUser.Response())
 }
 
 func (app *App) SignInHandler(w http.ResponseWriter, r *http.Request) {
@@ -378,54 +427,157 @@ func (app *App) SignInHandler(w http.ResponseWriter, r *http.Request) {
 		Password string `json:"password"`
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
-		writeJSONResponse(w, http.StatusBadRequest, ErrorResponse{err.Error()})
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	user, err := models.Authenticate(app.db(r), app.loginPolicy(), input.Email, input.Password, app.clientIP(r))
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	if user.TOTPEnabled() {
+		challenge, err := models.CreateMFAChallenge(app.db(r), user.ID)
+		if err != nil {
+			app.writeError(w, r, err)
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, challenge)
 		return
 	}
 
-	user, err := models.GetUserByEmail(app.DB, input.Email)
+	tokens, err := models.CreateSession(app.db(r), user.ID)
 	if err != nil {
-		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		app.writeError(w, r, err)
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password)); err != nil {
-		writeJSONResponse(w, http.StatusUnauthorized, ErrorResponse{"Invalid credentials"})
+	writeJSONResponse(w, http.StatusOK, tokens)
+}
+
+func (app *App) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+	if input.RefreshToken == "" {
+		app.writeError(w, r, models.InvalidField("refresh_token", "is required"))
 		return
 	}
 
-	token, err := models.GenerateToken(user.ID)
+	tokens, err := models.RefreshSession(app.db(r), input.RefreshToken)
 	if err != nil {
-		writeJSONResponse(w, http.StatusInternalServerError, ErrorResponse{err.Error()})
+		app.writeError(w, r, err)
 		return
 	}
 
-	writeJSONResponse(w, http.StatusOK, map[string]string{"token": token})
+	writeJSONResponse(w, http.StatusOK, tokens)
 }
 
+func (app *App) SignOutHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, _ := r.Context().Value("sessionID").(uint)
+
+	if err := models.RevokeSession(app.db(r), sessionID); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Signed out successfully"})
+}
+
+var (
+	errMissingToken     = models.Unauthorized("missing_token", "No Authorization token provided")
+	errMalformedToken   = models.Unauthorized("malformed_authorization", "Authorization header format must be Bearer {token}")
+	errInvalidToken     = models.Unauthorized("invalid_token", "Invalid or expired token")
+	errEmailNotVerified = models.Forbidden("email_not_verified", "Email address is not verified")
+)
+
+// AuthMiddleware accepts either a JWT access token or an API key, sent as
+// "Authorization: Bearer ..." or, for API keys, in the X-API-Key header.
 func (app *App) AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
-			writeJSONResponse(w, http.StatusUnauthorized, ErrorResponse{"No Authorization token provided"})
-			return
+		tokenStr := r.Header.Get("X-API-Key")
+		if tokenStr == "" {
+			authHeader := r.Header.Get("Authorization")
+			if authHeader == "" {
+				app.writeError(w, r, errMissingToken)
+				return
+			}
+
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 || parts[0] != "Bearer" {
+				app.writeError(w, r, errMalformedToken)
+				return
+			}
+
+			tokenStr = parts[1]
 		}
 
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			writeJSONResponse(w, http.StatusUnauthorized, ErrorResponse{"Authorization header format must be Bearer {token}"})
+		if app.authThrottled(w, r) {
 			return
 		}
 
-		tokenStr := parts[1]
+		var userID uint
+		ctx := r.Context()
+
+		if strings.HasPrefix(tokenStr, models.APIKeyPrefix) {
+			apiKey, err := models.AuthenticateAPIKey(app.db(r), tokenStr)
+			if err != nil {
+				if errors.Is(err, models.ErrInvalidAPIKey) {
+					app.countAuthFailure(r)
+				}
+				app.writeError(w, r, err)
+				return
+			}
+
+			userID = apiKey.UserID
+			ctx = context.WithValue(ctx, "apiKeyScopes", apiKey.ScopeList())
+		} else {
+			// Validate the token
+			claims, err := models.ValidateToken(tokenStr)
+			if err != nil {
+				app.countAuthFailure(r)
+				app.writeError(w, r, errInvalidToken)
+				return
+			}
+
+			// Access tokens are short-lived, but a revoked session must stop
+			// working right away, so every request checks the session table.
+			active, err := models.IsSessionActive(app.db(r), claims.SessionID, claims.UserID)
+			if err != nil {
+				app.writeError(w, r, err)
+				return
+			}
+			if !active {
+				app.countAuthFailure(r)
+				app.writeError(w, r, models.ErrSessionRevoked)
+				return
+			}
+
+			userID = claims.UserID
+			ctx = context.WithValue(ctx, "sessionID", claims.SessionID)
+		}
 
-		// Validate the token
-		claims, err := models.ValidateToken(tokenStr)
-		if err != nil {
-			writeJSONResponse(w, http.StatusUnauthorized, ErrorResponse{"Invalid or expired token"})
-			return
+		if !app.unverifiedAllowed(r) {
+			verified, err := models.IsEmailVerified(app.db(r), userID)
+			if err != nil {
+				app.writeError(w, r, err)
+				return
+			}
+			if !verified {
+				app.writeError(w, r, errEmailNotVerified)
+				return
+			}
 		}
-		ctx := context.WithValue(r.Context(), "userID", claims.UserID)
+
+		ctx = context.WithValue(ctx, "userID", userID)
+		logUserID(r, userID)
 
 		r = r.WithContext(ctx)
 
@@ -437,40 +589,33 @@ func (app *App) AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 
 // ----------------------------------------------
 // FILTER'S HANDLER
-func (app *App) FilterHandler(db *gorm.DB) http.HandlerFunc {
-	fmt.Println(1)
-	return func(w http.ResponseWriter, r *http.Request) {
-		page := 1
-		pageSize := 10
-		sort := "-created_at"
-
-		filters := models.Filters{
-			Page:         page,
-			PageSize:     pageSize,
-			Sort:         sort,
-			SortSafeList: []string{"created_at", "-created_at"},
-		}
+func (app *App) FilterHandler(w http.ResponseWriter, r *http.Request) {
+	page := 1
+	pageSize := 10
+	sort := "-created_at"
 
-		if err := filters.Validate(); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
+	filters := models.Filters{
+		Page:         page,
+		PageSize:     pageSize,
+		Sort:         sort,
+		SortSafeList: []string{"created_at", "-created_at"},
+	}
 
-		limit := models.Limit(filters)
-		offset := models.Offset(filters)
+	if err := filters.Validate(); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
 
-		posts, err := models.FetchPosts(db, limit, offset, filters.SortColumn(), filters.SortDirection())
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	limit := models.Limit(filters)
+	offset := models.Offset(filters)
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		if err := json.NewEncoder(w).Encode(posts); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
+	posts, err := models.FetchPosts(app.db(r), limit, offset, filters.SortColumn(), filters.SortDirection())
+	if err != nil {
+		app.writeError(w, r, err)
+		return
 	}
+
+	writeJSONResponse(w, http.StatusOK, posts)
 }
 
 // Companies CRUD
@@ -478,33 +623,32 @@ func (app *App) FilterHandler(db *gorm.DB) http.HandlerFunc {
 
 func (app *App) AddCompanyHandler(w http.ResponseWriter, r *http.Request) {
 	var req models.Company
-
-	err := json.NewDecoder(r.Body).Decode(&req)
-	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeJSON(r, &req); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
 
-	// Assuming you have a DB connection available as app.DB
-	err = models.CreateCompany(app.DB, req.Name, req.Description, req.StartDate, req.EndDate, req.Owners)
-	if err != nil {
-		http.Error(w, "Failed to add company", http.StatusInternalServerError)
+	// The creator is the first owner; other members are added afterwards.
+	userID, _ := r.Context().Value("userID").(uint)
+
+	if err := models.CreateCompany(app.db(r), req.Name, req.Description, req.StartDate, req.EndDate, userID); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
+	app.Metrics.CompaniesCreated.Inc()
 
 	writeJSONResponse(w, http.StatusCreated, map[string]string{"result": "Company added successfully"})
 }
 
 func (app *App) DeleteCompanyHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	id, err := routeID(r, "id", "company")
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		app.writeError(w, r, err)
 		return
 	}
 
-	if err := models.DeleteCompany(app.DB, uint(id)); err != nil {
-		http.Error(w, "Company not found", http.StatusNotFound)
+	if err := models.DeleteCompany(app.db(r), id); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
 
@@ -512,21 +656,20 @@ func (app *App) DeleteCompanyHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *App) UpdateCompanyHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	id, err := routeID(r, "id", "company")
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		app.writeError(w, r, err)
 		return
 	}
 
 	var req models.Company // Use the Company struct for decoding request body
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if err := decodeJSON(r, &req); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
 
-	if err := models.UpdateCompany(app.DB, uint(id), req.Name, req.Description, req.StartDate, req.EndDate, req.Owners); err != nil {
-		http.Error(w, "Failed to update company", http.StatusInternalServerError)
+	if err := models.UpdateCompany(app.db(r), id, req.Name, req.Description, req.StartDate, req.EndDate); err != nil {
+		app.writeError(w, r, err)
 		return
 	}
 
@@ -534,16 +677,15 @@ func (app *App) UpdateCompanyHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *App) GetCompanyHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["id"])
+	id, err := routeID(r, "id", "company")
 	if err != nil {
-		http.Error(w, "Invalid ID", http.StatusBadRequest)
+		app.writeError(w, r, err)
 		return
 	}
 
-	company, err := models.GetCompanyByID(app.DB, uint(id))
+	company, err := models.GetCompanyByID(app.db(r), id)
 	if err != nil {
-		http.Error(w, "Company not found", http.StatusNotFound)
+		app.writeError(w, r, err)
 		return
 	}
 
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Skapar/NGE/pkg/nge/database/migrations"
+	"github.com/Skapar/NGE/pkg/nge/mailer"
+)
+
+// PROBES
+// _________________________________________________________
+
+// healthCheck is one dependency checked by /readyz. The API cannot serve
+// requests while a critical check fails; the others only degrade it.
+type healthCheck struct {
+	Name     string
+	Critical bool
+	Check    func(ctx context.Context) error
+}
+
+// checkResult is public, so it never carries the error itself, which may
+// name hosts or drivers; failures are logged instead.
+type checkResult struct {
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+type ReadinessResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// healthChecks lists the dependencies of the running server.
+func (app *App) healthChecks() []healthCheck {
+	checks := []healthCheck{
+		{Name: "database", Critical: true, Check: func(ctx context.Context) error {
+			sqlDB, err := app.DB.DB()
+			if err != nil {
+				return err
+			}
+			return sqlDB.PingContext(ctx)
+		}},
+		{Name: "migrations", Critical: true, Check: func(ctx context.Context) error {
+			latest, err := migrations.Latest()
+			if err != nil {
+				return err
+			}
+			version, err := migrations.Version(app.DB.WithContext(ctx))
+			if err != nil {
+				return err
+			}
+			if version < latest {
+				return fmt.Errorf("schema is at version %d, expected %d", version, latest)
+			}
+			return nil
+		}},
+	}
+	// Emails are sent on a best effort basis, so the mailer is not critical.
+	if checker, ok := app.Mailer.(mailer.Checker); ok {
+		checks = append(checks, healthCheck{Name: "mailer", Check: checker.Check})
+	}
+	return checks
+}
+
+// livezHandler reports that the process is up. It checks nothing else, so
+// that a failing dependency does not get the server restarted.
+func livezHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadyzHandler runs every health check concurrently, each bounded by
+// HEALTH_CHECK_TIMEOUT. It answers 503 when a critical check fails.
+func (app *App) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := app.healthChecks()
+	results := make(map[string]checkResult, len(checks))
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check healthCheck) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(r.Context(), app.Config.HealthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.Check(ctx)
+			result := checkResult{
+				Status:    "ok",
+				Critical:  check.Critical,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = "failing"
+				app.logger(r).Warn("health check failed", "check", check.Name, "error", err)
+			}
+
+			mu.Lock()
+			results[check.Name] = result
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	response := ReadinessResponse{Status: "ok", Checks: results}
+	code := http.StatusOK
+	for _, result := range results {
+		if result.Status == "ok" {
+			continue
+		}
+		if result.Critical {
+			response.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+			break
+		}
+		response.Status = "degraded"
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSONResponse(w, code, response)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Skapar/NGE/pkg/nge/mailer"
+	"github.com/Skapar/NGE/pkg/nge/models"
+)
+
+// COMPANY INVITATIONS
+// _________________________________________________________
+
+func invitationToken(r *http.Request) (string, error) {
+	var input struct {
+		Token string `json:"token"`
+	}
+	if err := decodeJSON(r, &input); err != nil {
+		return "", err
+	}
+	if input.Token == "" {
+		return "", models.InvalidField("token", "is required")
+	}
+	return input.Token, nil
+}
+
+func (app *App) CreateInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+	if !models.IsCompanyRole(input.Role) {
+		app.writeError(w, r, models.ErrInvalidCompanyRole)
+		return
+	}
+
+	actor, _ := r.Context().Value("companyRole").(string)
+	if !canManageCompanyRole(actor, input.Role) {
+		app.writeError(w, r, models.Forbidden("company_role_required", "You cannot invite members with the "+input.Role+" role"))
+		return
+	}
+
+	companyID, _ := routeID(r, "id", "company")
+	company, err := models.GetCompanyByID(app.db(r), companyID)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+	inviter, err := app.currentUser(r)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	token, invitation, err := models.CreateCompanyInvitation(app.db(r), company.ID, inviter.ID, input.Email, input.Role)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	link := app.clientLink("/invitations/accept", token)
+	msg := mailer.Message{
+		To:      invitation.Email,
+		Subject: fmt.Sprintf("You are invited to join %s on NGE", company.Name),
+		Body: fmt.Sprintf("Hi,\n\n%s invited you to join %s on NGE as %s. "+
+			"Use the link below within %d days to accept or decline:\n\n%s\n\n"+
+			"If you do not know %s, you can ignore this email.",
+			inviter.Username, company.Name, invitation.Role, int(models.CompanyInvitationTTL.Hours()/24), link, inviter.Username),
+	}
+	if err := app.Mailer.Send(r.Context(), msg); err != nil {
+		app.logger(r).Error("sending invitation", "invitation_id", invitation.ID, "error", err)
+	}
+
+	writeJSONResponse(w, http.StatusCreated, invitation)
+}
+
+func (app *App) ListInvitationsHandler(w http.ResponseWriter, r *http.Request) {
+	companyID, _ := routeID(r, "id", "company")
+
+	invitations, err := models.GetPendingCompanyInvitations(app.db(r), companyID)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, invitations)
+}
+
+func (app *App) RevokeInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	companyID, _ := routeID(r, "id", "company")
+	invitationID, err := routeID(r, "invitationID", "invitation")
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	if err := models.RevokeCompanyInvitation(app.db(r), companyID, invitationID); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Invitation revoked successfully"})
+}
+
+// AcceptInvitationHandler adds the signed-in user to the company. The user
+// must be signed in with the invited email address.
+func (app *App) AcceptInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := invitationToken(r)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	member, err := models.AcceptCompanyInvitation(app.db(r), token, *user)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, member)
+}
+
+func (app *App) DeclineInvitationHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := invitationToken(r)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	if err := models.DeclineCompanyInvitation(app.db(r), token); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Invitation declined"})
+}
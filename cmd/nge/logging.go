@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
+
+	initializers "github.com/Skapar/NGE/pkg/nge/database/initializers"
+)
+
+// LOGGING
+// _________________________________________________________
+
+// maxRequestIDLength bounds the X-Request-ID accepted from clients.
+const maxRequestIDLength = 128
+
+// newLogger returns the logger configured by LOG_FORMAT and LOG_LEVEL.
+func newLogger(cfg *initializers.Config) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		return nil, fmt.Errorf("invalid LOG_LEVEL %q", cfg.LogLevel)
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	switch cfg.LogFormat {
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, options)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, options)), nil
+	default:
+		return nil, fmt.Errorf("invalid LOG_FORMAT %q", cfg.LogFormat)
+	}
+}
+
+// requestLog collects what the access log line needs from handlers deeper
+// in the chain, which only see copies of the request.
+type requestLog struct {
+	Route   string
+	UserID  uint
+	TraceID string
+}
+
+// statusRecorder captures the status code and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// validRequestID accepts the IDs generated by proxies and other services,
+// but nothing that could break a log line.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestLogger gives every request an ID, taken from X-Request-ID when the
+// client sent a usable one, returns it in the X-Request-ID header and logs
+// one line per request once it is done. It wraps the router so that
+// unmatched routes are logged too.
+func (app *App) RequestLogger(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		requestID := r.Header.Get("X-Request-ID")
+		if !validRequestID(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+
+		entry := &requestLog{}
+		ctx := context.WithValue(r.Context(), "requestID", requestID)
+		ctx = context.WithValue(ctx, "requestLog", entry)
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		level := slog.LevelInfo
+		if recorder.status >= 500 {
+			level = slog.LevelError
+		}
+
+		attrs := []slog.Attr{
+			slog.String("request_id", requestID),
+			slog.String("method", r.Method),
+			slog.String("route", entry.Route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", recorder.status),
+			slog.Int("bytes", recorder.bytes),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("remote_ip", app.clientIP(r)),
+		}
+		if entry.UserID != 0 {
+			attrs = append(attrs, slog.Uint64("user_id", uint64(entry.UserID)))
+		}
+		if entry.TraceID != "" {
+			attrs = append(attrs, slog.String("trace_id", entry.TraceID))
+		}
+		app.Logger.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+// recordRoute stores the template of the matched route, such as
+// /post/{id}, for the access log. It runs as router middleware, which only
+// sees requests that matched a route.
+func recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if entry, ok := r.Context().Value("requestLog").(*requestLog); ok {
+			if route := mux.CurrentRoute(r); route != nil {
+				entry.Route, _ = route.GetPathTemplate()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logUserID records the authenticated user for the access log.
+func logUserID(r *http.Request, userID uint) {
+	if entry, ok := r.Context().Value("requestLog").(*requestLog); ok {
+		entry.UserID = userID
+	}
+}
+
+// logger returns the application logger annotated with the request ID and,
+// when the request is traced, the trace ID.
+func (app *App) logger(r *http.Request) *slog.Logger {
+	logger := app.Logger
+	if requestID, ok := r.Context().Value("requestID").(string); ok {
+		logger = logger.With("request_id", requestID)
+	}
+	if spanContext := trace.SpanContextFromContext(r.Context()); spanContext.IsSampled() {
+		logger = logger.With("trace_id", spanContext.TraceID().String())
+	}
+	return logger
+}
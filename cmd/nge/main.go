@@ -1,59 +1,97 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
 
-	config "github.com/Skapar/NGE/pkg/nge/config"
-	"github.com/gorilla/mux"
+	initializers "github.com/Skapar/NGE/pkg/nge/database/initializers"
+	"github.com/Skapar/NGE/pkg/nge/mailer"
+	"github.com/Skapar/NGE/pkg/nge/metrics"
+	"github.com/Skapar/NGE/pkg/nge/oidc"
+	"github.com/Skapar/NGE/pkg/nge/ratelimit"
 	_ "github.com/lib/pq"
+	"github.com/spf13/pflag"
 	"gorm.io/gorm"
 )
 
 type App struct {
-	DB *gorm.DB
+	DB      *gorm.DB
+	Config  initializers.Config
+	Mailer  mailer.Mailer
+	OIDC    *oidc.Registry
+	Logger  *slog.Logger
+	Metrics *metrics.Metrics
+	// RateLimiter is nil when rate limiting is disabled.
+	RateLimiter *ratelimit.Limiter
+	// TrustedProxies are the parsed TRUSTED_PROXIES.
+	TrustedProxies []netip.Prefix
+	// background tracks the work started by goBackground.
+	background sync.WaitGroup
 }
 
-func main() {
-	r := mux.NewRouter()
-
-	db, err := config.Connect()
-
-	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
-	}
-	config.Migrate()
-	app := App{DB: db}
-
-	r.HandleFunc("/health", healthCheckHandler)
-
-	r.HandleFunc("/signup", app.SignUpHandler).Methods("POST")
-	r.HandleFunc("/signin", app.SignInHandler).Methods("POST")
-
-	r.HandleFunc("/deleteUser/{id}", app.AuthMiddleware(app.DeleteUserHandler)).Methods("DELETE")
+// db returns the database bound to the request context, so that queries
+// stop when the client goes away and are traced as part of the request.
+func (app *App) db(r *http.Request) *gorm.DB {
+	return app.DB.WithContext(r.Context())
+}
 
-	r.HandleFunc("/role", app.CreateRoleHandler).Methods("POST")
+const usage = `usage: nge [command] [flags]
 
-	r.HandleFunc("/events", app.AddEventHandler).Methods("POST")
-	r.HandleFunc("/events/{id}", app.GetEventHandler).Methods("GET")
-	r.HandleFunc("/events/{id}", app.DeleteEventHandler).Methods("DELETE")
-	r.HandleFunc("/events/{id}", app.UpdateEventHandler).Methods("PUT")
+commands:
+  serve                       serve the API (the default)
+  migrate up|down|status|create
+                              manage the database schema
+  seed                        create the default roles and admin, and sample data
+  create-admin                create an administrator account
+  users list|disable|enable   manage user accounts
+  roles sync                  restore the built-in roles and permissions
+  tokens revoke               sign users out and revoke their API keys
+  export                      write data as JSON
+  config print                show the effective configuration
 
-	r.HandleFunc("/post", app.addPost).Methods("POST")
-	r.HandleFunc("/post/{id}", app.getPostById).Methods("GET")
-	r.HandleFunc("/post/{id}", app.updatePostById).Methods("PUT")
-	r.HandleFunc("/post/{id}", app.deletePostById).Methods("DELETE")
-	r.HandleFunc("/posts", app.getAllPosts).Methods("GET")
-	r.HandleFunc("/filter", app.FilterHandler(app.DB)).Methods("GET")
+Every command accepts the configuration flags, see "nge config print --help".
+Run "nge COMMAND --help" for the flags of a command.`
 
-	r.HandleFunc("/company", app.AddCompanyHandler).Methods("POST")
-	r.HandleFunc("/company/{id}", app.GetCompanyHandler).Methods("GET")
-	r.HandleFunc("/company/{id}", app.UpdateCompanyHandler).Methods("PUT")
-	r.HandleFunc("/company/{id}", app.DeleteCompanyHandler).Methods("DELETE")
-	// r.HandleFunc("/getAllCompanies", app.GetAllCompaniesHandler).Methods("GET")
+// commands are the subcommands of the binary.
+var commands = map[string]func(args []string) error{
+	"serve":        runServe,
+	"migrate":      runMigrate,
+	"seed":         runSeed,
+	"create-admin": runCreateAdmin,
+	"users":        runUsers,
+	"roles":        runRoles,
+	"tokens":       runTokens,
+	"export":       runExport,
+	"config":       runConfig,
+}
 
-	fmt.Println("Server listening on port 8080")
+func main() {
+	// Without a command, or with only flags, the binary serves the API.
+	name, args := "serve", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
 
-	log.Fatal(http.ListenAndServe(":8080", r))
+	if name == "help" {
+		fmt.Println(usage)
+		return
+	}
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", name, usage)
+		os.Exit(2)
+	}
+	if err := run(args); err != nil {
+		if errors.Is(err, pflag.ErrHelp) {
+			return
+		}
+		log.Fatal(err)
+	}
 }
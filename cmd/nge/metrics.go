@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/Skapar/NGE/pkg/nge/models"
+)
+
+// METRICS
+// _________________________________________________________
+
+// Instrument counts and times every request by method, route template and
+// status. Requests that match no route share the "unmatched" route, and
+// made up methods the "OTHER" method, so that scanners cannot create
+// unbounded label values. It must run inside
+// RequestLogger, which provides the route.
+func (app *App) Instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		app.Metrics.HTTPInFlight.Inc()
+		defer app.Metrics.HTTPInFlight.Dec()
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r)
+
+		route := "unmatched"
+		if entry, ok := r.Context().Value("requestLog").(*requestLog); ok && entry.Route != "" {
+			route = entry.Route
+		}
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		status := strconv.Itoa(recorder.status)
+
+		method := metricMethod(r.Method)
+		app.Metrics.HTTPRequests.WithLabelValues(method, route, status).Inc()
+		app.Metrics.HTTPRequestDuration.WithLabelValues(method, route, status).Observe(time.Since(start).Seconds())
+	})
+}
+
+// metricMethod returns method if it is a standard HTTP method and "OTHER"
+// otherwise.
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// MetricsHandler serves the metrics in the Prometheus format. When
+// METRICS_TOKEN is set, scrapers must send it as a bearer token.
+func (app *App) MetricsHandler() http.HandlerFunc {
+	handler := promhttp.HandlerFor(app.Metrics.Registry, promhttp.HandlerOpts{})
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.Config.MetricsToken != "" {
+			expected := "Bearer " + app.Config.MetricsToken
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+				app.writeError(w, r, models.Unauthorized("invalid_metrics_token", "Invalid metrics token"))
+				return
+			}
+		}
+		handler.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/Skapar/NGE/pkg/nge/models"
+)
+
+// TWO-FACTOR AUTHENTICATION
+// _________________________________________________________
+
+type mfaCodeInput struct {
+	Code string `json:"code"`
+}
+
+func (app *App) EnrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	enrollment, err := models.BeginTOTPEnrollment(app.db(r), *user)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, enrollment)
+}
+
+func (app *App) ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input mfaCodeInput
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	codes, err := models.ConfirmTOTPEnrollment(app.db(r), *user, input.Code)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+func (app *App) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input mfaCodeInput
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	if err := models.DisableTOTP(app.db(r), *user, input.Code); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Two-factor authentication disabled"})
+}
+
+func (app *App) RegenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	var input mfaCodeInput
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	codes, err := models.RegenerateRecoveryCodes(app.db(r), *user, input.Code)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string][]string{"recovery_codes": codes})
+}
+
+// SignInMFAHandler is the second sign-in step for users with two-factor
+// authentication. It accepts a TOTP code or a recovery code.
+func (app *App) SignInMFAHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
+	}
+
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	user, err := models.CompleteMFAChallenge(app.db(r), app.loginPolicy(), input.MFAToken, input.Code, app.clientIP(r))
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	tokens, err := models.CreateSession(app.db(r), user.ID)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, tokens)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/spf13/pflag"
+	"gorm.io/gorm"
+
+	"github.com/Skapar/NGE/pkg/nge/database/migrations"
+)
+
+// MIGRATIONS
+// _________________________________________________________
+
+const migrateUsage = `usage: nge migrate <command> [flags]
+
+commands:
+  up               apply every pending migration
+  down [--steps N] roll back the last N applied migrations (default 1)
+  status           list migrations and whether they are applied
+  create NAME      add an empty migration pair to the source tree
+
+up, down and status accept the configuration flags, see "nge config print --help".`
+
+// checkSchema fails when migrations are pending, so that nothing runs
+// against a schema older than the code.
+func checkSchema(db *gorm.DB) error {
+	pending, err := migrations.Pending(db)
+	if err != nil {
+		return fmt.Errorf("failed to check database migrations: %w", err)
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("database schema is behind: %d pending migrations, starting with %04d_%s. Run \"nge migrate up\" first",
+			len(pending), pending[0].Version, pending[0].Name)
+	}
+	return nil
+}
+
+// runMigrate implements the "nge migrate" subcommands.
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	fs := pflag.NewFlagSet("migrate "+args[0], pflag.ContinueOnError)
+	connect := func() (*gorm.DB, error) {
+		_, db, err := openDB(fs, args[1:])
+		return db, err
+	}
+
+	switch args[0] {
+	case "up":
+		db, err := connect()
+		if err != nil {
+			return err
+		}
+		applied, err := migrations.Up(db)
+		for _, m := range applied {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return nil
+
+	case "down":
+		steps := fs.Int("steps", 1, "number of migrations to roll back")
+		db, err := connect()
+		if err != nil {
+			return err
+		}
+		if *steps < 1 {
+			return errors.New("--steps must be at least 1")
+		}
+		rolledBack, err := migrations.Down(db, *steps)
+		for _, m := range rolledBack {
+			fmt.Printf("rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+
+	case "status":
+		db, err := connect()
+		if err != nil {
+			return err
+		}
+		statuses, err := migrations.GetStatus(db)
+		if err != nil {
+			return err
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(tw, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return tw.Flush()
+
+	case "create":
+		dir := fs.String("dir", migrations.SourceDir, "directory holding the migration files")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errors.New("usage: nge migrate create [--dir DIR] NAME")
+		}
+
+		upPath, downPath, err := migrations.Create(*dir, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created %s\ncreated %s\n", upPath, downPath)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown migrate command %q\n\n%s", args[0], migrateUsage)
+	}
+}
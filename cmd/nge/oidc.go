@@ -0,0 +1,232 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Skapar/NGE/pkg/nge/models"
+	"github.com/Skapar/NGE/pkg/nge/oidc"
+)
+
+// OPENID CONNECT
+// _________________________________________________________
+
+var (
+	errProviderNotFound     = models.NotFound("provider_not_found", "unknown identity provider")
+	errProviderSignInFailed = models.Unauthorized("provider_sign_in_failed", "the identity provider did not confirm the sign-in")
+	errProviderEmail        = models.Invalid("provider_email", "The provider did not return a usable email address")
+)
+
+// oidcStateCookie holds the state of the flow the browser started, so that a
+// callback can only be completed by that browser. Without it, a link URL or
+// a sign-in could be finished in someone else's browser.
+const oidcStateCookie = "nge_oidc_state"
+
+// setOIDCStateCookie ties the state to the browser for as long as the state
+// is valid.
+func setOIDCStateCookie(w http.ResponseWriter, state string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/auth/oidc/",
+		MaxAge:   int(models.OIDCLoginStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// clearOIDCStateCookie removes the state cookie once the callback used it.
+func clearOIDCStateCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Path:     "/auth/oidc/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// oidcStateMatches reports whether the state of the callback is the one of
+// the state cookie.
+func oidcStateMatches(r *http.Request, state string) bool {
+	cookie, err := r.Cookie(oidcStateCookie)
+	return err == nil && state != "" && subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) == 1
+}
+
+// oidcProvider returns the provider named by the {provider} route variable.
+func (app *App) oidcProvider(r *http.Request) (*oidc.Provider, error) {
+	provider, err := app.OIDC.Get(mux.Vars(r)["provider"])
+	if errors.Is(err, oidc.ErrUnknownProvider) {
+		return nil, errProviderNotFound
+	}
+	return provider, err
+}
+
+// startOIDCFlow stores a pending sign-in, sets its state cookie and returns
+// the provider URL.
+func (app *App) startOIDCFlow(w http.ResponseWriter, r *http.Request, linkUserID *uint) (string, error) {
+	provider, err := app.oidcProvider(r)
+	if err != nil {
+		return "", err
+	}
+
+	verifier := oidc.GenerateVerifier()
+	state, login, err := models.CreateOIDCLoginState(app.db(r), provider.Name, verifier, linkUserID)
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, login.Nonce, verifier)
+	if err != nil {
+		return "", models.Upstream("provider_unavailable", "identity provider is unavailable").WithCause(err)
+	}
+	setOIDCStateCookie(w, state)
+	return authURL, nil
+}
+
+func (app *App) ListOIDCProvidersHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, http.StatusOK, map[string][]string{"providers": app.OIDC.Names()})
+}
+
+// OIDCLoginHandler redirects the browser to the provider's sign-in page.
+func (app *App) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	authURL, err := app.startOIDCFlow(w, r, nil)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCLinkHandler returns the provider URL that links an external account to
+// the signed-in user once the callback completes. The URL only works in the
+// browser that made the request, which gets the state cookie; a frontend on
+// another origin only keeps it with CORS_ALLOW_CREDENTIALS, which config
+// validation requires.
+func (app *App) OIDCLinkHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("userID").(uint)
+
+	authURL, err := app.startOIDCFlow(w, r, &userID)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"authorization_url": authURL})
+}
+
+func (app *App) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider, err := app.oidcProvider(r)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	query := r.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		app.writeError(w, r, models.Invalid("sign_in_not_completed", "Sign-in was not completed: "+providerErr))
+		return
+	}
+
+	state := query.Get("state")
+	if !oidcStateMatches(r, state) {
+		app.writeError(w, r, models.ErrInvalidLoginState)
+		return
+	}
+	clearOIDCStateCookie(w)
+
+	login, err := models.ConsumeOIDCLoginState(app.db(r), provider.Name, state)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), query.Get("code"), login.Nonce, login.CodeVerifier)
+	if err != nil {
+		// The cause, such as a bad id_token, is for the logs only.
+		app.logger(r).Warn("OIDC sign-in failed", "provider", provider.Name, "error", err)
+		app.writeError(w, r, errProviderSignInFailed)
+		return
+	}
+
+	external := models.ExternalIdentity{
+		Provider:      identity.Provider,
+		Subject:       identity.Subject,
+		Email:         identity.Email,
+		EmailVerified: identity.EmailVerified,
+		Username:      identity.Username,
+	}
+
+	if login.LinkUserID != nil {
+		link, err := models.LinkIdentity(app.db(r), *login.LinkUserID, external)
+		if err != nil {
+			app.writeError(w, r, err)
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, link)
+		return
+	}
+
+	user, err := models.SignInWithIdentity(app.db(r), external)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidEmail) {
+			err = errProviderEmail
+		}
+		app.writeError(w, r, err)
+		return
+	}
+
+	// Signing in at a provider replaces the password, not the second factor.
+	if user.TOTPEnabled() {
+		challenge, err := models.CreateMFAChallenge(app.db(r), user.ID)
+		if err != nil {
+			app.writeError(w, r, err)
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, challenge)
+		return
+	}
+
+	tokens, err := models.CreateSession(app.db(r), user.ID)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, tokens)
+}
+
+func (app *App) ListIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("userID").(uint)
+
+	identities, err := models.GetUserIdentities(app.db(r), userID)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, identities)
+}
+
+func (app *App) UnlinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	userID, _ := r.Context().Value("userID").(uint)
+
+	identityID, err := routeID(r, "id", "identity")
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	if err := models.UnlinkIdentity(app.db(r), userID, identityID); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Identity unlinked successfully"})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+
+	"gorm.io/gorm"
+
+	"github.com/Skapar/NGE/pkg/nge/models"
+)
+
+// OWNERSHIP
+// _________________________________________________________
+
+// ownedResource describes a resource that can only be changed by its owner,
+// or by anyone holding the moderate permission. Companies have several
+// members with different roles and use RequireCompanyRole instead.
+type ownedResource struct {
+	Name     string
+	Write    string
+	Moderate string
+	IsOwner  func(db *gorm.DB, resourceID, userID uint) (bool, error)
+}
+
+var (
+	postResource = ownedResource{
+		Name:     "post",
+		Write:    models.PermPostsWrite,
+		Moderate: models.PermPostsModerate,
+		IsOwner:  models.IsPostAuthor,
+	}
+	eventResource = ownedResource{
+		Name:     "event",
+		Write:    models.PermEventsWrite,
+		Moderate: models.PermEventsModerate,
+		IsOwner:  models.IsEventOwner,
+	}
+)
+
+// hasPermission reports whether the request may use permission: the user's
+// role must grant it and an API key must have it in its scopes.
+func (app *App) hasPermission(r *http.Request, permission string) (bool, error) {
+	if !scopeAllowed(r, permission) {
+		return false, nil
+	}
+	userID, _ := r.Context().Value("userID").(uint)
+	return models.UserHasPermission(app.db(r), userID, permission)
+}
+
+// RequireOwnership lets the request through when the signed-in user owns the
+// resource named by the {id} route variable and may write it, or when they
+// may moderate it. It must be wrapped by AuthMiddleware.
+func (app *App) RequireOwnership(resource ownedResource, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resourceID, err := routeID(r, "id", resource.Name)
+		if err != nil {
+			app.writeError(w, r, err)
+			return
+		}
+
+		userID, _ := r.Context().Value("userID").(uint)
+		owner, err := resource.IsOwner(app.db(r), resourceID, userID)
+		if err != nil {
+			app.writeError(w, r, err)
+			return
+		}
+
+		permission := resource.Moderate
+		if owner {
+			permission = resource.Write
+		}
+		allowed, err := app.hasPermission(r, permission)
+		if err == nil && !allowed && owner {
+			// Owners who lost the write permission can still be moderators.
+			allowed, err = app.hasPermission(r, resource.Moderate)
+		}
+		if err != nil {
+			app.writeError(w, r, err)
+			return
+		}
+		if !allowed {
+			app.writeError(w, r, models.Forbidden("not_owner", "Only the owner or a moderator can change this "+resource.Name))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/Skapar/NGE/pkg/nge/mailer"
+	"github.com/Skapar/NGE/pkg/nge/models"
+)
+
+// PASSWORD RESET
+// _________________________________________________________
+
+func (app *App) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	// The response is the same whether or not the email is registered so the
+	// endpoint cannot be used to find out which addresses have accounts.
+	response := map[string]string{"message": "If that email is registered, a password reset link has been sent"}
+
+	// Both paths answer right away and the email is sent afterwards, so the
+	// response time does not tell either.
+	user, err := models.GetUserByEmail(app.db(r), strings.TrimSpace(input.Email))
+	if err == nil {
+		logger := app.logger(r)
+		app.goBackground(r, func(ctx context.Context, db *gorm.DB) {
+			if err := app.sendPasswordReset(ctx, db, *user); err != nil {
+				logger.Error("sending password reset email", "user_id", user.ID, "error", err)
+			}
+		})
+	}
+
+	writeJSONResponse(w, http.StatusAccepted, response)
+}
+
+// sendPasswordReset creates a reset token for the user and emails the link.
+func (app *App) sendPasswordReset(ctx context.Context, db *gorm.DB, user models.User) error {
+	token, err := models.CreatePasswordResetToken(db, user.ID)
+	if err != nil {
+		return err
+	}
+
+	link := app.clientLink("/password/reset", token)
+	return app.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your NGE password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your NGE account. "+
+			"Use the link below within %d minutes to choose a new one:\n\n%s\n\n"+
+			"If you did not ask for this, you can ignore this email.", user.Username, int(models.PasswordResetTTL.Minutes()), link),
+	})
+}
+
+func (app *App) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	if err := models.ResetPassword(app.db(r), input.Token, input.Password); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Password has been reset"})
+}
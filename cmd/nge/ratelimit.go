@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	initializers "github.com/Skapar/NGE/pkg/nge/database/initializers"
+	"github.com/Skapar/NGE/pkg/nge/models"
+	"github.com/Skapar/NGE/pkg/nge/ratelimit"
+)
+
+// RATE LIMITING
+// _________________________________________________________
+
+// Route groups that share a rate limit policy.
+const (
+	// rateLimitAuth covers the routes that check credentials or send
+	// emails, which are the ones worth hammering.
+	rateLimitAuth = "auth"
+	// rateLimitWrite covers the routes that change data.
+	rateLimitWrite = "write"
+	// rateLimitRead covers the routes that only read data.
+	rateLimitRead = "read"
+)
+
+// newRateLimiter returns the limiter configured by the RATE_LIMIT_*
+// settings, or nil when RATE_LIMIT_ENABLED is off. Buckets are kept in
+// memory, so each server instance applies the limits on its own.
+func newRateLimiter(cfg *initializers.Config) (*ratelimit.Limiter, error) {
+	if !cfg.RateLimitEnabled {
+		return nil, nil
+	}
+
+	limiter := &ratelimit.Limiter{Store: ratelimit.NewMemoryStore(), Policies: map[string]ratelimit.Policy{}}
+	for group, setting := range map[string]string{
+		rateLimitAuth:  cfg.RateLimitAuth,
+		rateLimitWrite: cfg.RateLimitWrite,
+		rateLimitRead:  cfg.RateLimitRead,
+	} {
+		policy, err := ratelimit.ParsePolicy(setting)
+		if err != nil {
+			return nil, err
+		}
+		limiter.Policies[group] = policy
+	}
+	return limiter, nil
+}
+
+// RateLimit applies the policy of group to next. Authenticated clients are
+// limited by user ID, so it must run inside AuthMiddleware on authenticated
+// routes, and anonymous ones by IP address. Every limited response carries
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers; a
+// request over the limit gets 429 with Retry-After.
+func (app *App) RateLimit(group string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if app.RateLimiter == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := app.ipRateLimitKey(r)
+		if userID, ok := r.Context().Value("userID").(uint); ok {
+			key = "user:" + strconv.FormatUint(uint64(userID), 10)
+		}
+
+		result, err := app.RateLimiter.Take(r.Context(), group, key)
+		if err != nil {
+			// An unavailable store must not take the API down with it.
+			app.logger(r).Error("rate limiter failed, letting the request through", "group", group, "error", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if result.Limit == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if app.writeRateLimit(w, r, group, result) {
+			next.ServeHTTP(w, r)
+		}
+	}
+}
+
+// ipRateLimitKey is the bucket key of the client address of r.
+func (app *App) ipRateLimitKey(r *http.Request) string {
+	return "ip:" + app.clientIP(r)
+}
+
+// writeRateLimit sets the rate limit headers of result and, when the request
+// is over the limit, writes the 429 response. It reports whether the request
+// may go on.
+func (app *App) writeRateLimit(w http.ResponseWriter, r *http.Request, group string, result ratelimit.Result) bool {
+	policy := app.RateLimiter.Policies[group]
+	w.Header().Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Limit, seconds(policy.Period)))
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+
+	if !result.Allowed {
+		msg := fmt.Sprintf("Too many requests, retry in %d seconds", seconds(result.RetryAfter))
+		app.writeError(w, r, models.TooManyRequests("rate_limited", msg).WithRetryAfter(result.RetryAfter))
+		return false
+	}
+	return true
+}
+
+// authThrottled checks, before any credential is looked up, whether the
+// client address has used up the auth policy, and writes the 429 response
+// when it has. AuthMiddleware runs before RateLimit, so without this a
+// client could guess tokens and API keys as fast as the database answers.
+func (app *App) authThrottled(w http.ResponseWriter, r *http.Request) bool {
+	if app.RateLimiter == nil {
+		return false
+	}
+	result, err := app.RateLimiter.Peek(r.Context(), rateLimitAuth, app.ipRateLimitKey(r))
+	if err != nil {
+		app.logger(r).Error("rate limiter failed, letting the request through", "group", rateLimitAuth, "error", err)
+		return false
+	}
+	if result.Allowed {
+		return false
+	}
+	app.writeRateLimit(w, r, rateLimitAuth, result)
+	return true
+}
+
+// countAuthFailure counts a rejected token or API key against the auth
+// policy of the client address, like a failed sign-in.
+func (app *App) countAuthFailure(r *http.Request) {
+	if app.RateLimiter == nil {
+		return
+	}
+	if _, err := app.RateLimiter.Take(r.Context(), rateLimitAuth, app.ipRateLimitKey(r)); err != nil {
+		app.logger(r).Error("rate limiter failed", "group", rateLimitAuth, "error", err)
+	}
+}
+
+// seconds rounds d up to whole seconds, as the rate limit headers expect.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/Skapar/NGE/pkg/nge/models"
+)
+
+// ROLES AND PERMISSIONS
+// _________________________________________________________
+
+var errInsufficientPermissions = models.Forbidden("insufficient_permissions", "Insufficient permissions")
+
+// RequirePermission rejects users whose role does not grant permission, and
+// API keys whose scopes do not include it. It must be wrapped by
+// AuthMiddleware.
+func (app *App) RequirePermission(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed, err := app.hasPermission(r, permission)
+		if err != nil {
+			app.writeError(w, r, err)
+			return
+		}
+		if !allowed {
+			if !scopeAllowed(r, permission) {
+				app.writeError(w, r, models.Forbidden("missing_scope", "API key is missing the "+permission+" scope"))
+				return
+			}
+			app.writeError(w, r, errInsufficientPermissions)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+type roleInput struct {
+	Title       string   `json:"title"`
+	Permissions []string `json:"permissions"`
+}
+
+func (app *App) ListPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, http.StatusOK, models.AllPermissions)
+}
+
+func (app *App) ListRolesHandler(w http.ResponseWriter, r *http.Request) {
+	roles, err := models.GetRoles(app.db(r))
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, roles)
+}
+
+func (app *App) CreateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	var input roleInput
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+	if input.Title == "" {
+		app.writeError(w, r, models.InvalidField("title", "is required"))
+		return
+	}
+
+	createdRole, err := models.CreateRole(app.db(r), input.Title, input.Permissions)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusCreated, createdRole)
+}
+
+func (app *App) UpdateRoleHandler(w http.ResponseWriter, r *http.Request) {
+	roleID, err := routeID(r, "id", "role")
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	var input roleInput
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	updatedRole, err := models.UpdateRole(app.db(r), int(roleID), input.Title, input.Permissions)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, updatedRole)
+}
+
+func (app *App) DeleteRoleHandler(w http.ResponseWriter, r *http.Request) {
+	roleID, err := routeID(r, "id", "role")
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	if err := models.DeleteRole(app.db(r), int(roleID)); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Role deleted successfully"})
+}
+
+func (app *App) AssignRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userID, err := routeID(r, "id", "user")
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	var input struct {
+		RoleID int `json:"role_id"`
+	}
+	if err := decodeJSON(r, &input); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	if err := models.AssignRole(app.db(r), userID, input.RoleID); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Role assigned successfully"})
+}
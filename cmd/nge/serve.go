@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/spf13/pflag"
+	gormlogger "gorm.io/gorm/logger"
+
+	initializers "github.com/Skapar/NGE/pkg/nge/database/initializers"
+	"github.com/Skapar/NGE/pkg/nge/mailer"
+	"github.com/Skapar/NGE/pkg/nge/metrics"
+	"github.com/Skapar/NGE/pkg/nge/models"
+	"github.com/Skapar/NGE/pkg/nge/oidc"
+	"github.com/Skapar/NGE/pkg/nge/tracing"
+)
+
+// SERVER
+// _________________________________________________________
+
+// runServe implements "nge serve", the default command: it checks that the
+// schema is current and serves the API.
+func runServe(args []string) error {
+	cfg, db, err := openDB(pflag.NewFlagSet("serve", pflag.ContinueOnError), args)
+	if err != nil {
+		return err
+	}
+
+	logger, err := newLogger(&cfg)
+	if err != nil {
+		return err
+	}
+	// Packages that log through log or slog end up in the same output.
+	slog.SetDefault(logger)
+	db.Logger = gormlogger.New(slog.NewLogLogger(logger.Handler(), slog.LevelWarn), gormlogger.Config{
+		SlowThreshold:             200 * time.Millisecond,
+		LogLevel:                  gormlogger.Warn,
+		IgnoreRecordNotFoundError: true,
+	})
+
+	appMetrics := metrics.New()
+	if err := db.Use(appMetrics.GormPlugin()); err != nil {
+		return fmt.Errorf("failed to instrument the database: %w", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	if err := appMetrics.RegisterDBStats(sqlDB, "nge"); err != nil {
+		return err
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), &cfg)
+	if err != nil {
+		return fmt.Errorf("failed to configure tracing: %w", err)
+	}
+	if err := db.Use(tracing.GormPlugin()); err != nil {
+		return fmt.Errorf("failed to trace the database: %w", err)
+	}
+
+	if err := checkSchema(db); err != nil {
+		return err
+	}
+
+	if err := models.InitSigningKeys(&cfg); err != nil {
+		return fmt.Errorf("failed to load JWT signing keys: %w", err)
+	}
+
+	mail, err := mailer.New(&cfg)
+	if err != nil {
+		return fmt.Errorf("failed to configure mailer: %w", err)
+	}
+
+	providers, err := oidc.NewRegistry(&cfg)
+	if err != nil {
+		return fmt.Errorf("failed to configure OIDC providers: %w", err)
+	}
+
+	limiter, err := newRateLimiter(&cfg)
+	if err != nil {
+		return fmt.Errorf("failed to configure rate limits: %w", err)
+	}
+
+	trustedProxies, err := cfg.TrustedProxyPrefixes()
+	if err != nil {
+		return err
+	}
+
+	app := App{DB: db, Config: cfg, Mailer: mail, OIDC: providers, Logger: logger, Metrics: appMetrics, RateLimiter: limiter, TrustedProxies: trustedProxies}
+
+	srv := &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           app.RequestLogger(app.Trace(app.Instrument(app.CORS(app.routes())))),
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+		ReadTimeout:       cfg.HTTPReadTimeout,
+		ReadHeaderTimeout: cfg.HTTPReadHeaderTimeout,
+		WriteTimeout:      cfg.HTTPWriteTimeout,
+		IdleTimeout:       cfg.HTTPIdleTimeout,
+	}
+
+	// ctx is cancelled by SIGTERM or SIGINT, which starts the shutdown.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	var workers sync.WaitGroup
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		app.cleanupExpired(ctx, cfg.CleanupInterval)
+	}()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+	logger.Info("server listening", "addr", cfg.ListenAddr)
+	if cfg.ClientOrigin == "" {
+		logger.Warn("CLIENT_ORIGIN is not set, password reset and invitation links point to APP_BASE_URL, where the API cannot open them")
+	}
+	if cfg.MetricsEnabled && cfg.MetricsToken == "" {
+		logger.Warn("METRICS_TOKEN is not set, anyone can read /metrics")
+	}
+
+	select {
+	case err := <-serveErr:
+		// The server never started, typically because the address is taken.
+		stop()
+		workers.Wait()
+		shutdownTracing(context.Background())
+		initializers.CloseDB()
+		return err
+	case <-ctx.Done():
+	}
+	// A second signal kills the process instead of waiting for the drain.
+	stop()
+
+	logger.Info("shutting down, waiting for requests in progress", "timeout", cfg.ShutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	shutdownErr := srv.Shutdown(shutdownCtx)
+	if shutdownErr != nil {
+		// Whatever did not finish in time is cut off.
+		srv.Close()
+		shutdownErr = fmt.Errorf("requests still running after %s were aborted: %w", cfg.ShutdownTimeout, shutdownErr)
+	}
+	workers.Wait()
+	app.background.Wait()
+	// Send the spans of the last requests before exiting.
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Warn("flushing traces failed", "error", err)
+	}
+	if err := initializers.CloseDB(); err != nil {
+		return fmt.Errorf("closing the database: %w", err)
+	}
+
+	logger.Info("server stopped")
+	return shutdownErr
+}
+
+func (app *App) routes() *mux.Router {
+	r := mux.NewRouter()
+	r.Use(recordRoute)
+	r.NotFoundHandler = http.HandlerFunc(app.notFoundHandler)
+	r.MethodNotAllowedHandler = http.HandlerFunc(app.methodNotAllowedHandler)
+
+	r.HandleFunc("/health", healthCheckHandler)
+	r.HandleFunc("/livez", livezHandler).Methods("GET")
+	r.HandleFunc("/readyz", app.ReadyzHandler).Methods("GET")
+	if app.Config.MetricsEnabled {
+		r.HandleFunc("/metrics", app.MetricsHandler()).Methods("GET")
+	}
+	r.HandleFunc("/.well-known/jwks.json", jwksHandler).Methods("GET")
+
+	r.HandleFunc("/signup", app.RateLimit(rateLimitAuth, app.SignUpHandler)).Methods("POST")
+	r.HandleFunc("/signin", app.RateLimit(rateLimitAuth, app.SignInHandler)).Methods("POST")
+	r.HandleFunc("/signin/mfa", app.RateLimit(rateLimitAuth, app.SignInMFAHandler)).Methods("POST")
+	r.HandleFunc("/token/refresh", app.RateLimit(rateLimitAuth, app.RefreshTokenHandler)).Methods("POST")
+	r.HandleFunc("/signout", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireSession(app.SignOutHandler)))).Methods("POST")
+	r.HandleFunc("/password/forgot", app.RateLimit(rateLimitAuth, app.ForgotPasswordHandler)).Methods("POST")
+	r.HandleFunc("/password/reset", app.RateLimit(rateLimitAuth, app.ResetPasswordHandler)).Methods("POST")
+	r.HandleFunc("/verify-email", app.RateLimit(rateLimitAuth, app.VerifyEmailHandler)).Methods("GET", "POST")
+	r.HandleFunc("/verify-email/resend", app.AuthMiddleware(app.RateLimit(rateLimitAuth, app.ResendVerificationHandler))).Methods("POST")
+
+	r.HandleFunc("/2fa/totp/enroll", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireSession(app.EnrollTOTPHandler)))).Methods("POST")
+	r.HandleFunc("/2fa/totp/confirm", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireSession(app.ConfirmTOTPHandler)))).Methods("POST")
+	r.HandleFunc("/2fa/totp/disable", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireSession(app.DisableTOTPHandler)))).Methods("POST")
+	r.HandleFunc("/2fa/recovery-codes", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireSession(app.RegenerateRecoveryCodesHandler)))).Methods("POST")
+
+	r.HandleFunc("/auth/oidc", app.RateLimit(rateLimitRead, app.ListOIDCProvidersHandler)).Methods("GET")
+	r.HandleFunc("/auth/oidc/{provider}/login", app.RateLimit(rateLimitAuth, app.OIDCLoginHandler)).Methods("GET")
+	r.HandleFunc("/auth/oidc/{provider}/callback", app.RateLimit(rateLimitAuth, app.OIDCCallbackHandler)).Methods("GET")
+	r.HandleFunc("/auth/oidc/{provider}/link", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireSession(app.OIDCLinkHandler)))).Methods("POST")
+	r.HandleFunc("/auth/identities", app.AuthMiddleware(app.RateLimit(rateLimitRead, app.ListIdentitiesHandler))).Methods("GET")
+	r.HandleFunc("/auth/identities/{id}", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireSession(app.UnlinkIdentityHandler)))).Methods("DELETE")
+
+	r.HandleFunc("/api-keys", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireSession(app.CreateAPIKeyHandler)))).Methods("POST")
+	r.HandleFunc("/api-keys", app.AuthMiddleware(app.RateLimit(rateLimitRead, app.RequireSession(app.ListAPIKeysHandler)))).Methods("GET")
+	r.HandleFunc("/api-keys/{id}", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireSession(app.RevokeAPIKeyHandler)))).Methods("DELETE")
+
+	r.HandleFunc("/deleteUser/{id}", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequirePermission(models.PermUsersDelete, app.DeleteUserHandler)))).Methods("DELETE")
+	r.HandleFunc("/users/{id}/unlock", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequirePermission(models.PermUsersManage, app.UnlockUserHandler)))).Methods("POST")
+	r.HandleFunc("/users/{id}/role", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequirePermission(models.PermUsersManage, app.AssignRoleHandler)))).Methods("PUT")
+
+	r.HandleFunc("/permissions", app.AuthMiddleware(app.RateLimit(rateLimitRead, app.RequirePermission(models.PermRolesManage, app.ListPermissionsHandler)))).Methods("GET")
+	r.HandleFunc("/roles", app.AuthMiddleware(app.RateLimit(rateLimitRead, app.RequirePermission(models.PermRolesManage, app.ListRolesHandler)))).Methods("GET")
+	r.HandleFunc("/role", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequirePermission(models.PermRolesManage, app.CreateRoleHandler)))).Methods("POST")
+	r.HandleFunc("/roles/{id}", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequirePermission(models.PermRolesManage, app.UpdateRoleHandler)))).Methods("PUT")
+	r.HandleFunc("/roles/{id}", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequirePermission(models.PermRolesManage, app.DeleteRoleHandler)))).Methods("DELETE")
+
+	r.HandleFunc("/events", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequirePermission(models.PermEventsWrite, app.AddEventHandler)))).Methods("POST")
+	r.HandleFunc("/events/{id}", app.RateLimit(rateLimitRead, app.GetEventHandler)).Methods("GET")
+	r.HandleFunc("/events/{id}", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireOwnership(eventResource, app.DeleteEventHandler)))).Methods("DELETE")
+	r.HandleFunc("/events/{id}", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireOwnership(eventResource, app.UpdateEventHandler)))).Methods("PUT")
+
+	r.HandleFunc("/post", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequirePermission(models.PermPostsWrite, app.addPost)))).Methods("POST")
+	r.HandleFunc("/post/{id}", app.RateLimit(rateLimitRead, app.getPostById)).Methods("GET")
+	r.HandleFunc("/post/{id}", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireOwnership(postResource, app.updatePostById)))).Methods("PUT")
+	r.HandleFunc("/post/{id}", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireOwnership(postResource, app.deletePostById)))).Methods("DELETE")
+	r.HandleFunc("/posts", app.RateLimit(rateLimitRead, app.getAllPosts)).Methods("GET")
+	r.HandleFunc("/filter", app.RateLimit(rateLimitRead, app.FilterHandler)).Methods("GET")
+
+	r.HandleFunc("/company", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequirePermission(models.PermCompaniesWrite, app.AddCompanyHandler)))).Methods("POST")
+	r.HandleFunc("/company/{id}", app.RateLimit(rateLimitRead, app.GetCompanyHandler)).Methods("GET")
+	r.HandleFunc("/company/{id}", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireCompanyRole(models.CompanyRoleAdmin, app.UpdateCompanyHandler)))).Methods("PUT")
+	r.HandleFunc("/company/{id}", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireCompanyRole(models.CompanyRoleOwner, app.DeleteCompanyHandler)))).Methods("DELETE")
+	r.HandleFunc("/company/{id}/members", app.AuthMiddleware(app.RateLimit(rateLimitRead, app.RequireCompanyRole(models.CompanyRoleViewer, app.ListCompanyMembersHandler)))).Methods("GET")
+	r.HandleFunc("/company/{id}/members", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireCompanyRole(models.CompanyRoleAdmin, app.AddCompanyMemberHandler)))).Methods("POST")
+	r.HandleFunc("/company/{id}/members/{userID}", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireCompanyRole(models.CompanyRoleAdmin, app.UpdateCompanyMemberHandler)))).Methods("PUT")
+	r.HandleFunc("/company/{id}/members/{userID}", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireCompanyRole(models.CompanyRoleViewer, app.RemoveCompanyMemberHandler)))).Methods("DELETE")
+	r.HandleFunc("/company/{id}/transfer", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireCompanyRole(models.CompanyRoleOwner, app.TransferCompanyOwnershipHandler)))).Methods("POST")
+	r.HandleFunc("/company/{id}/invitations", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireCompanyRole(models.CompanyRoleAdmin, app.CreateInvitationHandler)))).Methods("POST")
+	r.HandleFunc("/company/{id}/invitations", app.AuthMiddleware(app.RateLimit(rateLimitRead, app.RequireCompanyRole(models.CompanyRoleAdmin, app.ListInvitationsHandler)))).Methods("GET")
+	r.HandleFunc("/company/{id}/invitations/{invitationID}", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.RequireCompanyRole(models.CompanyRoleAdmin, app.RevokeInvitationHandler)))).Methods("DELETE")
+	r.HandleFunc("/invitations/accept", app.AuthMiddleware(app.RateLimit(rateLimitWrite, app.AcceptInvitationHandler))).Methods("POST")
+	r.HandleFunc("/invitations/decline", app.RateLimit(rateLimitAuth, app.DeclineInvitationHandler)).Methods("POST")
+	// r.HandleFunc("/getAllCompanies", app.GetAllCompaniesHandler).Methods("GET")
+
+	return r
+}
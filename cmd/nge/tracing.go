@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/Skapar/NGE/pkg/nge/tracing"
+)
+
+// TRACING
+// _________________________________________________________
+
+// Trace runs every request in a server span, continuing the trace of the
+// caller when the request has a W3C traceparent header. The span is named
+// after the method and route template, such as "PUT /post/{id}", and the
+// database queries of the handler become its children. It must run inside
+// RequestLogger, which provides the route.
+func (app *App) Trace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				semconv.URLPath(r.URL.Path),
+				semconv.ClientAddress(app.clientIP(r)),
+				semconv.UserAgentOriginal(r.UserAgent()),
+			))
+		defer span.End()
+
+		entry, _ := ctx.Value("requestLog").(*requestLog)
+		if entry != nil && span.SpanContext().IsSampled() {
+			entry.TraceID = span.SpanContext().TraceID().String()
+		}
+
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+
+		if entry != nil && entry.Route != "" {
+			span.SetName(r.Method + " " + entry.Route)
+			span.SetAttributes(semconv.HTTPRoute(entry.Route))
+		}
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.status))
+		// Client errors are the client's problem, not a failure of the server.
+		if recorder.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(recorder.status))
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/Skapar/NGE/pkg/nge/mailer"
+	"github.com/Skapar/NGE/pkg/nge/models"
+)
+
+// EMAIL VERIFICATION
+// _________________________________________________________
+
+func (app *App) sendVerificationEmail(ctx context.Context, user models.User) error {
+	token, err := models.GenerateEmailVerificationToken(user)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/verify-email?token=%s", strings.TrimRight(app.Config.AppBaseURL, "/"), url.QueryEscape(token))
+	return app.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your NGE email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below within %d hours:\n\n%s\n\n"+
+			"If you did not create an NGE account, you can ignore this email.", user.Username, int(models.EmailVerificationTTL.Hours()), link),
+	})
+}
+
+// unverifiedAllowed reports whether users with an unverified email may call
+// the route matched for r.
+func (app *App) unverifiedAllowed(r *http.Request) bool {
+	if !app.Config.EmailVerificationRequired {
+		return true
+	}
+
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	template, err := route.GetPathTemplate()
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range app.Config.UnverifiedAllowedRoutes {
+		if strings.TrimSpace(allowed) == template {
+			return true
+		}
+	}
+	return false
+}
+
+func (app *App) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if r.Method == http.MethodPost {
+		var input struct {
+			Token string `json:"token"`
+		}
+		if err := decodeJSON(r, &input); err != nil {
+			app.writeError(w, r, err)
+			return
+		}
+		token = input.Token
+	}
+
+	if token == "" {
+		app.writeError(w, r, models.InvalidField("token", "is required"))
+		return
+	}
+
+	if _, err := models.VerifyEmail(app.db(r), token); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	writeJSONResponse(w, http.StatusOK, map[string]string{"message": "Email address verified"})
+}
+
+func (app *App) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := app.currentUser(r)
+	if err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	if err := models.MarkVerificationSent(app.db(r), *user, app.Config.VerificationResendInterval); err != nil {
+		app.writeError(w, r, err)
+		return
+	}
+
+	if err := app.sendVerificationEmail(r.Context(), *user); err != nil {
+		app.writeError(w, r, models.Upstream("email_failed", "Failed to send verification email").WithCause(err))
+		return
+	}
+
+	writeJSONResponse(w, http.StatusAccepted, map[string]string{"message": "Verification email sent"})
+}
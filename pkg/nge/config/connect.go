@@ -0,0 +1,16 @@
+package config
+
+import (
+	initializers "github.com/Skapar/NGE/pkg/nge/database/initializers"
+
+	"gorm.io/gorm"
+)
+
+// Connect opens the database configured in cfg.
+func Connect(cfg *initializers.Config) (*gorm.DB, error) {
+	if err := initializers.ConnectDB(cfg); err != nil {
+		return nil, err
+	}
+
+	return initializers.GetDB(), nil
+}
@@ -1,33 +1,364 @@
 package initializers
 
 import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
+// Config holds every setting of the server. Settings are read from the
+// environment, an app.env file and command line flags; see LoadConfig.
+// Fields tagged secret are redacted by WriteRedacted.
 type Config struct {
+	// The database is DBurl when DATABASE_URL is set, otherwise it is built
+	// from the POSTGRES_* settings.
 	DBHost         string `mapstructure:"POSTGRES_HOST"`
 	DBUserName     string `mapstructure:"POSTGRES_USER"`
-	DBUserPassword string `mapstructure:"POSTGRES_PASSWORD"`
+	DBUserPassword string `mapstructure:"POSTGRES_PASSWORD" secret:"true"`
 	DBName         string `mapstructure:"POSTGRES_DB"`
 	DBPort         string `mapstructure:"POSTGRES_PORT"`
-	ServerPort     string `mapstructure:"PORT"`
-	DBurl          string `mapstructure:"DATABASE_URL"`
+	DBSSLMode      string `mapstructure:"POSTGRES_SSLMODE"`
+	DBurl          string `mapstructure:"DATABASE_URL" secret:"true"`
+
+	// Connection pool. Zero means unlimited for the sizes and lifetimes.
+	DBMaxOpenConns    int           `mapstructure:"DB_MAX_OPEN_CONNS"`
+	DBMaxIdleConns    int           `mapstructure:"DB_MAX_IDLE_CONNS"`
+	DBConnMaxLifetime time.Duration `mapstructure:"DB_CONN_MAX_LIFETIME"`
+	DBConnMaxIdleTime time.Duration `mapstructure:"DB_CONN_MAX_IDLE_TIME"`
+
+	// ListenAddr is the address the HTTP server listens on. It defaults to
+	// ":" + PORT.
+	ServerPort string `mapstructure:"PORT"`
+	ListenAddr string `mapstructure:"LISTEN_ADDR"`
+
+	HTTPReadTimeout       time.Duration `mapstructure:"HTTP_READ_TIMEOUT"`
+	HTTPReadHeaderTimeout time.Duration `mapstructure:"HTTP_READ_HEADER_TIMEOUT"`
+	HTTPWriteTimeout      time.Duration `mapstructure:"HTTP_WRITE_TIMEOUT"`
+	HTTPIdleTimeout       time.Duration `mapstructure:"HTTP_IDLE_TIMEOUT"`
+	// ShutdownTimeout is how long in-flight requests get to finish after
+	// SIGTERM or SIGINT before the server closes their connections.
+	ShutdownTimeout time.Duration `mapstructure:"SHUTDOWN_TIMEOUT"`
+
+	// LogLevel is debug, info, warn or error, and LogFormat json or text.
+	LogLevel  string `mapstructure:"LOG_LEVEL"`
+	LogFormat string `mapstructure:"LOG_FORMAT"`
+
+	// MetricsEnabled serves Prometheus metrics on /metrics. It is off by
+	// default since the metrics are public unless MetricsToken is set, in
+	// which case scrapers must send it as a bearer token.
+	MetricsEnabled bool   `mapstructure:"METRICS_ENABLED"`
+	MetricsToken   string `mapstructure:"METRICS_TOKEN" secret:"true"`
+
+	// TracingExporter sends OpenTelemetry spans to TracingOTLPEndpoint with
+	// "otlp", prints them with "stdout", or turns tracing off with "none".
+	// TracingSampleRatio is the share of new traces that are recorded.
+	TracingExporter     string  `mapstructure:"TRACING_EXPORTER"`
+	TracingOTLPEndpoint string  `mapstructure:"TRACING_OTLP_ENDPOINT"`
+	TracingServiceName  string  `mapstructure:"TRACING_SERVICE_NAME"`
+	TracingSampleRatio  float64 `mapstructure:"TRACING_SAMPLE_RATIO"`
+
+	// Rate limits per route group, written as LIMIT/PERIOD such as 10/1m,
+	// or empty for no limit. Clients are told apart by user ID when
+	// authenticated and by IP address otherwise.
+	RateLimitEnabled bool   `mapstructure:"RATE_LIMIT_ENABLED"`
+	RateLimitAuth    string `mapstructure:"RATE_LIMIT_AUTH"`
+	RateLimitWrite   string `mapstructure:"RATE_LIMIT_WRITE"`
+	RateLimitRead    string `mapstructure:"RATE_LIMIT_READ"`
+
+	// HealthCheckTimeout bounds each dependency check of /readyz.
+	HealthCheckTimeout time.Duration `mapstructure:"HEALTH_CHECK_TIMEOUT"`
+
+	// CleanupInterval is how often expired sessions and tokens are deleted.
+	// Zero disables the cleanup.
+	CleanupInterval time.Duration `mapstructure:"CLEANUP_INTERVAL"`
+
+	// CORSAllowedOrigins lists the origins browsers may call the API from,
+	// such as https://app.example.com, https://*.example.com or *.
+	// CLIENT_ORIGIN is always included.
+	ClientOrigin       string   `mapstructure:"CLIENT_ORIGIN"`
+	CORSAllowedOrigins []string `mapstructure:"CORS_ALLOWED_ORIGINS"`
+	// CORSAllowedHeaders are the request headers browsers may send, * for
+	// any, and CORSExposedHeaders the response headers scripts may read.
+	CORSAllowedMethods   []string      `mapstructure:"CORS_ALLOWED_METHODS"`
+	CORSAllowedHeaders   []string      `mapstructure:"CORS_ALLOWED_HEADERS"`
+	CORSExposedHeaders   []string      `mapstructure:"CORS_EXPOSED_HEADERS"`
+	CORSAllowCredentials bool          `mapstructure:"CORS_ALLOW_CREDENTIALS"`
+	CORSMaxAge           time.Duration `mapstructure:"CORS_MAX_AGE"`
+
+	// JWTAlgorithm is one of HS256, RS256 or EdDSA.
+	JWTAlgorithm string `mapstructure:"JWT_ALGORITHM"`
+	// JWTKeyID is sent as the "kid" header of every issued token.
+	JWTKeyID string `mapstructure:"JWT_KEY_ID"`
+	// JWTSecret is the shared secret used with HS256.
+	JWTSecret string `mapstructure:"JWT_SECRET" secret:"true"`
+	// JWTPrivateKeyFile is a PEM encoded RSA or Ed25519 private key used with RS256 and EdDSA.
+	JWTPrivateKeyFile string `mapstructure:"JWT_PRIVATE_KEY_FILE"`
+	// JWTVerificationKeys lists retired public keys that are still accepted,
+	// as comma separated kid=path-to-pem pairs.
+	JWTVerificationKeys string `mapstructure:"JWT_VERIFICATION_KEYS"`
+	// JWTPreviousSecrets lists retired HS256 secrets that are still accepted,
+	// as comma separated kid=secret pairs.
+	JWTPreviousSecrets string `mapstructure:"JWT_PREVIOUS_SECRETS" secret:"true"`
+
+	// TrustedProxies lists the addresses or CIDR ranges of the reverse
+	// proxies in front of the server. Only requests coming from them may set
+	// the client address with X-Forwarded-For.
+	TrustedProxies []string `mapstructure:"TRUSTED_PROXIES"`
+
+	// AppBaseURL is used to build the links sent in emails.
+	AppBaseURL string `mapstructure:"APP_BASE_URL"`
+
+	// Mailer selects how emails are delivered: "smtp" or "log".
+	Mailer       string `mapstructure:"MAILER"`
+	MailFrom     string `mapstructure:"MAIL_FROM"`
+	MailLogFile  string `mapstructure:"MAIL_LOG_FILE"`
+	SMTPHost     string `mapstructure:"SMTP_HOST"`
+	SMTPPort     int    `mapstructure:"SMTP_PORT"`
+	SMTPUsername string `mapstructure:"SMTP_USERNAME"`
+	SMTPPassword string `mapstructure:"SMTP_PASSWORD" secret:"true"`
 
-	ClientOrigin string `mapstructure:"CLIENT_ORIGIN"`
+	// EmailVerificationRequired blocks users with an unverified email from
+	// every authenticated route except UnverifiedAllowedRoutes.
+	EmailVerificationRequired bool     `mapstructure:"EMAIL_VERIFICATION_REQUIRED"`
+	UnverifiedAllowedRoutes   []string `mapstructure:"UNVERIFIED_ALLOWED_ROUTES"`
+	// VerificationResendInterval is the minimum time between two
+	// verification emails for the same user.
+	VerificationResendInterval time.Duration `mapstructure:"VERIFICATION_RESEND_INTERVAL"`
+
+	// BcryptCost is the work factor for password hashes. Changing it rehashes
+	// passwords as users sign in.
+	BcryptCost int `mapstructure:"BCRYPT_COST"`
+
+	// Sign-in throttling, see models.LoginPolicy.
+	LoginBackoffThreshold   int           `mapstructure:"LOGIN_BACKOFF_THRESHOLD"`
+	LoginBackoffBase        time.Duration `mapstructure:"LOGIN_BACKOFF_BASE"`
+	LoginBackoffMax         time.Duration `mapstructure:"LOGIN_BACKOFF_MAX"`
+	LoginMaxFailures        int           `mapstructure:"LOGIN_MAX_FAILURES"`
+	LoginLockoutDuration    time.Duration `mapstructure:"LOGIN_LOCKOUT_DURATION"`
+	LoginIPBackoffThreshold int           `mapstructure:"LOGIN_IP_BACKOFF_THRESHOLD"`
+	LoginIPWindow           time.Duration `mapstructure:"LOGIN_IP_WINDOW"`
+	// LoginAttemptRetention is how long login attempts are kept for audits.
+	// Zero keeps them forever.
+	LoginAttemptRetention time.Duration `mapstructure:"LOGIN_ATTEMPT_RETENTION"`
+
+	// The administrator created by "nge seed" when SeedAdminEmail is set.
+	SeedAdminEmail    string `mapstructure:"SEED_ADMIN_EMAIL"`
+	SeedAdminUsername string `mapstructure:"SEED_ADMIN_USERNAME"`
+	SeedAdminPassword string `mapstructure:"SEED_ADMIN_PASSWORD" secret:"true"`
+
+	// OIDCProviderNames lists the enabled OpenID Connect providers. Each one
+	// is configured with OIDC_<NAME>_* settings and ends up in OIDCProviders.
+	OIDCProviderNames []string                      `mapstructure:"OIDC_PROVIDERS"`
+	OIDCProviders     map[string]OIDCProviderConfig `mapstructure:"-"`
+}
+
+type OIDCProviderConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// loadOIDCProviders reads OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET,
+// _REDIRECT_URL and _SCOPES for every name in OIDC_PROVIDERS.
+func loadOIDCProviders(names []string) map[string]OIDCProviderConfig {
+	providers := map[string]OIDCProviderConfig{}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		scopes := strings.Fields(strings.ReplaceAll(viper.GetString(prefix+"SCOPES"), ",", " "))
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "email", "profile"}
+		}
+
+		providers[name] = OIDCProviderConfig{
+			Issuer:       viper.GetString(prefix + "ISSUER"),
+			ClientID:     viper.GetString(prefix + "CLIENT_ID"),
+			ClientSecret: viper.GetString(prefix + "CLIENT_SECRET"),
+			RedirectURL:  viper.GetString(prefix + "REDIRECT_URL"),
+			Scopes:       scopes,
+		}
+	}
+	return providers
+}
+
+// settingKeys returns the names of all settings, in the order of Config.
+func settingKeys() []string {
+	t := reflect.TypeOf(Config{})
+	keys := make([]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		if key := t.Field(i).Tag.Get("mapstructure"); key != "" && key != "-" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
 }
 
+// BindFlags adds a flag for every setting to fs, named after the setting in
+// lower case with dashes (DATABASE_URL becomes --database-url), plus a
+// --config flag. Flags override the environment and the config file. It
+// returns the value of --config, to pass to LoadConfig once fs is parsed.
+func BindFlags(fs *pflag.FlagSet) *string {
+	path := fs.String("config", ".", "directory containing app.env, or the path of a config file")
+	for _, key := range settingKeys() {
+		name := strings.ToLower(strings.ReplaceAll(key, "_", "-"))
+		fs.String(name, "", "overrides "+key)
+		viper.BindPFlag(key, fs.Lookup(name))
+	}
+	return path
+}
+
+// LoadConfig reads the settings from the environment and from path, which is
+// either a directory containing app.env or the path of a config file. The
+// file is optional when path is a directory. Flags registered with BindFlags
+// take precedence over both.
 func LoadConfig(path string) (config Config, err error) {
-	viper.AddConfigPath(path)
+	if info, statErr := os.Stat(path); statErr == nil && info.IsDir() {
+		viper.AddConfigPath(path)
+		viper.SetConfigName("app")
+	} else {
+		viper.SetConfigFile(path)
+	}
 	viper.SetConfigType("env")
-	viper.SetConfigName("app")
 
 	viper.AutomaticEnv()
+	// AutomaticEnv only applies to keys viper already knows about.
+	for _, key := range settingKeys() {
+		viper.BindEnv(key)
+	}
+
+	viper.SetDefault("POSTGRES_PORT", "5432")
+	viper.SetDefault("POSTGRES_SSLMODE", "prefer")
+	viper.SetDefault("DB_MAX_OPEN_CONNS", 25)
+	viper.SetDefault("DB_MAX_IDLE_CONNS", 25)
+	viper.SetDefault("DB_CONN_MAX_LIFETIME", "30m")
+	viper.SetDefault("DB_CONN_MAX_IDLE_TIME", "5m")
+
+	viper.SetDefault("PORT", "8080")
+	viper.SetDefault("LISTEN_ADDR", "")
+	viper.SetDefault("HTTP_READ_TIMEOUT", "15s")
+	viper.SetDefault("HTTP_READ_HEADER_TIMEOUT", "5s")
+	viper.SetDefault("HTTP_WRITE_TIMEOUT", "30s")
+	viper.SetDefault("HTTP_IDLE_TIMEOUT", "2m")
+	viper.SetDefault("SHUTDOWN_TIMEOUT", "30s")
+	viper.SetDefault("LOG_LEVEL", "info")
+	viper.SetDefault("LOG_FORMAT", "json")
+	viper.SetDefault("METRICS_ENABLED", false)
+	viper.SetDefault("METRICS_TOKEN", "")
+	viper.SetDefault("TRACING_EXPORTER", "none")
+	viper.SetDefault("TRACING_OTLP_ENDPOINT", "http://localhost:4318/v1/traces")
+	viper.SetDefault("TRACING_SERVICE_NAME", "nge")
+	viper.SetDefault("TRACING_SAMPLE_RATIO", 1.0)
+	viper.SetDefault("RATE_LIMIT_ENABLED", true)
+	viper.SetDefault("RATE_LIMIT_AUTH", "10/1m")
+	viper.SetDefault("RATE_LIMIT_WRITE", "60/1m")
+	viper.SetDefault("RATE_LIMIT_READ", "300/1m")
+	viper.SetDefault("HEALTH_CHECK_TIMEOUT", "2s")
+	viper.SetDefault("CLEANUP_INTERVAL", "1h")
+
+	viper.SetDefault("CLIENT_ORIGIN", "")
+	viper.SetDefault("CORS_ALLOWED_ORIGINS", "")
+	viper.SetDefault("CORS_ALLOWED_METHODS", "GET,POST,PUT,PATCH,DELETE")
+	viper.SetDefault("CORS_ALLOWED_HEADERS", "Authorization,Content-Type,X-API-Key,X-Request-ID,traceparent,tracestate")
+	viper.SetDefault("CORS_EXPOSED_HEADERS", "X-Request-ID,Retry-After,RateLimit-Policy,RateLimit-Limit,RateLimit-Remaining,RateLimit-Reset")
+	viper.SetDefault("CORS_ALLOW_CREDENTIALS", false)
+	viper.SetDefault("CORS_MAX_AGE", "10m")
+
+	viper.SetDefault("JWT_ALGORITHM", "HS256")
+	viper.SetDefault("JWT_KEY_ID", "default")
+	viper.SetDefault("JWT_SECRET", "")
+	viper.SetDefault("JWT_PRIVATE_KEY_FILE", "")
+	viper.SetDefault("JWT_VERIFICATION_KEYS", "")
+	viper.SetDefault("JWT_PREVIOUS_SECRETS", "")
+
+	viper.SetDefault("TRUSTED_PROXIES", "")
+	viper.SetDefault("APP_BASE_URL", "http://localhost:8080")
+	viper.SetDefault("MAILER", "log")
+	viper.SetDefault("MAIL_FROM", "NGE <no-reply@localhost>")
+	viper.SetDefault("MAIL_LOG_FILE", "")
+	viper.SetDefault("SMTP_HOST", "")
+	viper.SetDefault("SMTP_PORT", 587)
+	viper.SetDefault("SMTP_USERNAME", "")
+	viper.SetDefault("SMTP_PASSWORD", "")
+
+	viper.SetDefault("EMAIL_VERIFICATION_REQUIRED", true)
+	viper.SetDefault("UNVERIFIED_ALLOWED_ROUTES", "/signout,/verify-email/resend")
+	viper.SetDefault("VERIFICATION_RESEND_INTERVAL", "2m")
+
+	viper.SetDefault("BCRYPT_COST", 14)
+
+	viper.SetDefault("LOGIN_BACKOFF_THRESHOLD", 3)
+	viper.SetDefault("LOGIN_BACKOFF_BASE", "1s")
+	viper.SetDefault("LOGIN_BACKOFF_MAX", "5m")
+	viper.SetDefault("LOGIN_MAX_FAILURES", 10)
+	viper.SetDefault("LOGIN_LOCKOUT_DURATION", "15m")
+	viper.SetDefault("LOGIN_IP_BACKOFF_THRESHOLD", 20)
+	viper.SetDefault("LOGIN_IP_WINDOW", "15m")
+	viper.SetDefault("LOGIN_ATTEMPT_RETENTION", "720h")
+
+	viper.SetDefault("SEED_ADMIN_EMAIL", "")
+	viper.SetDefault("SEED_ADMIN_USERNAME", "admin")
+	viper.SetDefault("SEED_ADMIN_PASSWORD", "")
+
+	viper.SetDefault("OIDC_PROVIDERS", "")
 
 	err = viper.ReadInConfig()
 	if err != nil {
-		return
+		// Without an app.env file every setting comes from the environment
+		// and flags.
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) {
+			return
+		}
+		err = nil
 	}
 
 	err = viper.Unmarshal(&config)
+	if err != nil {
+		return
+	}
+
+	if config.ListenAddr == "" {
+		config.ListenAddr = ":" + config.ServerPort
+	}
+	config.TrustedProxies = trimList(config.TrustedProxies)
+	config.CORSAllowedOrigins = trimList(config.CORSAllowedOrigins)
+	config.CORSAllowedMethods = trimList(config.CORSAllowedMethods)
+	config.CORSAllowedHeaders = trimList(config.CORSAllowedHeaders)
+	config.CORSExposedHeaders = trimList(config.CORSExposedHeaders)
+	if config.ClientOrigin != "" && !contains(config.CORSAllowedOrigins, config.ClientOrigin) {
+		config.CORSAllowedOrigins = append(config.CORSAllowedOrigins, config.ClientOrigin)
+	}
+	config.OIDCProviders = loadOIDCProviders(config.OIDCProviderNames)
 	return
 }
+
+// trimList drops blanks left over from splitting comma separated values.
+func trimList(values []string) []string {
+	trimmed := []string{}
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			trimmed = append(trimmed, value)
+		}
+	}
+	return trimmed
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
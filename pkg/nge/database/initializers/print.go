@@ -0,0 +1,74 @@
+package initializers
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const redacted = "<redacted>"
+
+// formatSetting renders a setting the way it would be written in app.env.
+func formatSetting(value reflect.Value) string {
+	switch v := value.Interface().(type) {
+	case []string:
+		return strings.Join(v, ",")
+	case time.Duration:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// WriteRedacted writes the effective configuration to w in app.env format,
+// with secrets replaced by a placeholder.
+func (c Config) WriteRedacted(w io.Writer) error {
+	v := reflect.ValueOf(c)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("mapstructure")
+		if key == "" || key == "-" {
+			continue
+		}
+
+		value := formatSetting(v.Field(i))
+		if field.Tag.Get("secret") == "true" && value != "" {
+			value = redacted
+			// The rest of a database URL is useful when debugging.
+			if key == "DATABASE_URL" {
+				if u, err := url.Parse(c.DBurl); err == nil {
+					value = u.Redacted()
+				}
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", key, value); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(c.OIDCProviders))
+	for name := range c.OIDCProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		provider := c.OIDCProviders[name]
+		secret := ""
+		if provider.ClientSecret != "" {
+			secret = redacted
+		}
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		_, err := fmt.Fprintf(w, "%sISSUER=%s\n%sCLIENT_ID=%s\n%sCLIENT_SECRET=%s\n%sREDIRECT_URL=%s\n%sSCOPES=%s\n",
+			prefix, provider.Issuer, prefix, provider.ClientID, prefix, secret,
+			prefix, provider.RedirectURL, prefix, strings.Join(provider.Scopes, ","))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package initializers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/Skapar/NGE/pkg/nge/ratelimit"
+)
+
+// DSN returns DATABASE_URL, or a connection URL built from the POSTGRES_*
+// settings when it is not set.
+func (c Config) DSN() string {
+	if c.DBurl != "" {
+		return c.DBurl
+	}
+
+	dsn := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(c.DBUserName, c.DBUserPassword),
+		Host:   net.JoinHostPort(c.DBHost, c.DBPort),
+		Path:   "/" + c.DBName,
+	}
+	if c.DBSSLMode != "" {
+		dsn.RawQuery = url.Values{"sslmode": {c.DBSSLMode}}.Encode()
+	}
+	return dsn.String()
+}
+
+// TrustedProxyPrefixes parses TRUSTED_PROXIES. A single address stands for
+// itself.
+func (c Config) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, proxy := range c.TrustedProxies {
+		if addr, err := netip.ParseAddr(proxy); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES entry %q must be an IP address or a CIDR range such as 10.0.0.0/8", proxy)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Validate checks that the required settings are present and that the others
+// make sense, and reports every problem at once.
+func (c Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.DBurl != "" {
+		if _, err := url.Parse(c.DBurl); err != nil {
+			fail("DATABASE_URL is not a valid URL")
+		}
+	} else if c.DBHost == "" || c.DBUserName == "" || c.DBName == "" {
+		fail("set DATABASE_URL, or POSTGRES_HOST, POSTGRES_USER and POSTGRES_DB")
+	}
+	if c.DBMaxOpenConns < 0 || c.DBMaxIdleConns < 0 {
+		fail("DB_MAX_OPEN_CONNS and DB_MAX_IDLE_CONNS must not be negative")
+	}
+	if c.DBConnMaxLifetime < 0 || c.DBConnMaxIdleTime < 0 {
+		fail("DB_CONN_MAX_LIFETIME and DB_CONN_MAX_IDLE_TIME must not be negative")
+	}
+
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		fail("LISTEN_ADDR %q must be host:port or :port", c.ListenAddr)
+	}
+	for _, timeout := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"HTTP_READ_TIMEOUT", c.HTTPReadTimeout},
+		{"HTTP_READ_HEADER_TIMEOUT", c.HTTPReadHeaderTimeout},
+		{"HTTP_WRITE_TIMEOUT", c.HTTPWriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", c.HTTPIdleTimeout},
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout},
+		{"CLEANUP_INTERVAL", c.CleanupInterval},
+		{"LOGIN_ATTEMPT_RETENTION", c.LoginAttemptRetention},
+		{"CORS_MAX_AGE", c.CORSMaxAge},
+	} {
+		if timeout.value < 0 {
+			fail("%s must not be negative", timeout.name)
+		}
+	}
+	// Throttling counts the attempts within these windows.
+	if c.LoginAttemptRetention > 0 && (c.LoginAttemptRetention < c.LoginLockoutDuration || c.LoginAttemptRetention < c.LoginIPWindow) {
+		fail("LOGIN_ATTEMPT_RETENTION must be at least LOGIN_LOCKOUT_DURATION and LOGIN_IP_WINDOW")
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		fail("LOG_LEVEL %q must be debug, info, warn or error", c.LogLevel)
+	}
+	if c.LogFormat != "json" && c.LogFormat != "text" {
+		fail("LOG_FORMAT %q must be json or text", c.LogFormat)
+	}
+	switch c.TracingExporter {
+	case "none", "stdout":
+	case "otlp":
+		if u, err := url.Parse(c.TracingOTLPEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("TRACING_OTLP_ENDPOINT %q must look like http://collector:4318/v1/traces", c.TracingOTLPEndpoint)
+		}
+	default:
+		fail("TRACING_EXPORTER %q must be otlp, stdout or none", c.TracingExporter)
+	}
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		fail("TRACING_SAMPLE_RATIO must be between 0 and 1")
+	}
+	for _, limit := range []struct {
+		name, value string
+	}{
+		{"RATE_LIMIT_AUTH", c.RateLimitAuth},
+		{"RATE_LIMIT_WRITE", c.RateLimitWrite},
+		{"RATE_LIMIT_READ", c.RateLimitRead},
+	} {
+		if _, err := ratelimit.ParsePolicy(limit.value); err != nil {
+			fail("%s: %v", limit.name, err)
+		}
+	}
+	if _, err := c.TrustedProxyPrefixes(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.HealthCheckTimeout <= 0 {
+		fail("HEALTH_CHECK_TIMEOUT must be positive")
+	}
+	for _, origin := range c.CORSAllowedOrigins {
+		if origin == "*" {
+			if c.CORSAllowCredentials {
+				fail("CORS_ALLOW_CREDENTIALS cannot be used with the * origin, list the origins instead")
+			}
+			continue
+		}
+		if strings.Count(origin, "*") > 1 {
+			fail("CORS origin %q may contain a single *", origin)
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			fail("CORS origin %q must look like https://example.com or https://*.example.com", origin)
+			continue
+		}
+		// With credentials a wildcard must stay inside one registered
+		// domain, or a pattern such as https://* trusts every site.
+		if c.CORSAllowCredentials && strings.Contains(origin, "*") {
+			host := u.Hostname()
+			if suffix, ok := strings.CutPrefix(host, "*."); !ok || !strings.Contains(suffix, ".") || strings.Contains(suffix, "*") {
+				fail("CORS origin %q must have a fixed domain after the *, such as https://*.example.com, when CORS_ALLOW_CREDENTIALS is on", origin)
+			}
+		}
+	}
+	for _, method := range c.CORSAllowedMethods {
+		if method != strings.ToUpper(method) || strings.ContainsAny(method, " */") {
+			fail("CORS method %q must be an upper case HTTP method such as GET", method)
+		}
+	}
+
+	switch c.JWTAlgorithm {
+	case "HS256":
+		if c.JWTSecret == "" {
+			fail("JWT_SECRET is required when JWT_ALGORITHM is HS256")
+		}
+	case "RS256", "EdDSA":
+		if c.JWTPrivateKeyFile == "" {
+			fail("JWT_PRIVATE_KEY_FILE is required when JWT_ALGORITHM is %s", c.JWTAlgorithm)
+		}
+	default:
+		fail("JWT_ALGORITHM %q must be HS256, RS256 or EdDSA", c.JWTAlgorithm)
+	}
+
+	if u, err := url.Parse(c.AppBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		fail("APP_BASE_URL %q must be an http or https URL", c.AppBaseURL)
+	}
+	switch c.Mailer {
+	case "", "log":
+	case "smtp":
+		if c.SMTPHost == "" {
+			fail("SMTP_HOST is required when MAILER is smtp")
+		}
+	default:
+		fail("MAILER %q must be smtp or log", c.Mailer)
+	}
+
+	if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+		fail("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	if c.SeedAdminEmail != "" && len(c.SeedAdminPassword) < 8 {
+		fail("SEED_ADMIN_PASSWORD must be at least 8 characters when SEED_ADMIN_EMAIL is set")
+	}
+
+	for name, provider := range c.OIDCProviders {
+		if provider.Issuer == "" || provider.ClientID == "" || provider.RedirectURL == "" {
+			fail("OIDC provider %s needs OIDC_%s_ISSUER, _CLIENT_ID and _REDIRECT_URL", name, strings.ToUpper(name))
+		}
+	}
+	// Linking a provider sets the state cookie on the response to a call
+	// from the frontend, which browsers drop from other origins without
+	// credentials.
+	if len(c.OIDCProviders) > 0 && len(c.CORSAllowedOrigins) > 0 && !c.CORSAllowCredentials {
+		fail("CORS_ALLOW_CREDENTIALS must be on when OIDC_PROVIDERS is set and the frontend is on another origin, or linking providers cannot work")
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,112 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Skapar/NGE/pkg/nge/models"
+)
+
+// upChecksums are the SHA-256 sums of the released up migrations. Databases
+// that already applied a migration never run it again, so editing one
+// silently splits the schema; add a new migration instead.
+var upChecksums = map[uint]string{
+	1:  "6a3f86cd8b0c9aadd69b55eac693920110977aee001d733ddd7b68192a4e91fe",
+	2:  "8e7c0d339bf66055d78106d91d39d3167fe9ac5834eb069c8ac6e9cbdd3b017a",
+	3:  "59ab9de1084d99bb06e866bf3a8ce5b0048b066b1d2e4d49f107a5255e848d37",
+	4:  "dcf91f1ffb278ec157ff04dee7b662f21567a92a21da3eb2d5c97f57cd279521",
+	5:  "b90cf02d5fe7b079cfdc4fb94362dd970f798396f862da4f9c6a81ac78c61428",
+	6:  "8c81ceda40d11e0ec258a8e1573a06090ef15c2dcdcd697a57e77c247faa907e",
+	7:  "57f24dbf8a10a534f69adfdf25f8904754087540439caf576d3af3b08a7ae273",
+	8:  "f2eea0449d81d39f3b0f11696d3f6bf062b20d8914a274196c4d3bcbc97b8044",
+	9:  "2a66090d676133e064b74e8dedee228a86ca1a78043278eb978ca40ad0e922fb",
+	10: "1d591ac98c2adf70a1a5d28aec10ef55c17583abe63820edc9fee7cebdea966b",
+	11: "b0c9fe63a346330aee4359eb6af936428e8eac9a33d090c760cbdb7616a0c35d",
+	12: "7a85706b5d89f87dc60b9fa288cd965f58ad4ff863c7809ae0ba8a8b53962daa",
+	13: "766e00615fe6461949e0e1ea4165c5fb8f99ab0d8ed435d72bc6523b22069efa",
+	14: "d5ab2b12535a9fb987bc042d2f1fdce7c0badfd7ecf39c39d4cdd88883a7e786",
+	15: "5f6e065bde1d239ff9808589ea94f94c3ed98bb13decd3bdec104419da98b05d",
+}
+
+func TestLoadOrder(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations are embedded")
+	}
+	for i, m := range migrations {
+		if m.Version != uint(i+1) {
+			t.Fatalf("migration %d_%s is at position %d, versions must start at 1 without gaps", m.Version, m.Name, i)
+		}
+		if m.Down == "" {
+			t.Errorf("migration %d_%s has no down file", m.Version, m.Name)
+		}
+	}
+}
+
+func TestLoadChecksums(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations {
+		sum := sha256.Sum256([]byte(m.Up))
+		got := hex.EncodeToString(sum[:])
+		want, ok := upChecksums[m.Version]
+		if !ok {
+			t.Errorf("migration %d_%s has no checksum, add %d: %q to upChecksums", m.Version, m.Name, m.Version, got)
+			continue
+		}
+		if got != want {
+			t.Errorf("migration %d_%s was changed after its release, add a new migration instead", m.Version, m.Name)
+		}
+	}
+}
+
+// The server no longer creates permissions at startup, so a permission the
+// code checks must be inserted by a migration or nobody can ever hold it.
+func TestPermissionsSeeded(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var up strings.Builder
+	for _, m := range migrations {
+		up.WriteString(m.Up)
+	}
+	for _, permission := range models.AllPermissions {
+		if !strings.Contains(up.String(), "'"+permission.Name+"'") {
+			t.Errorf("permission %s is not inserted by any migration", permission.Name)
+		}
+	}
+}
+
+func TestCreate(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"0001_a.up.sql", "0001_a.down.sql", "0007_b.up.sql", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	up, down, err := Create(dir, " Add User Settings! ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "0008_add_user_settings.up.sql"); up != want {
+		t.Errorf("up = %s, want %s", up, want)
+	}
+	if want := filepath.Join(dir, "0008_add_user_settings.down.sql"); down != want {
+		t.Errorf("down = %s, want %s", down, want)
+	}
+
+	if _, _, err := Create(dir, "--"); err == nil {
+		t.Error("Create accepted a name without letters or digits")
+	}
+}
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS company_owners;
+DROP TABLE IF EXISTS companies;
+DROP TABLE IF EXISTS events;
+DROP TABLE IF EXISTS posts;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS roles;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_users_email_lower;
+-- The plaintext passwords are gone for good; only the column comes back.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS shown_password text;
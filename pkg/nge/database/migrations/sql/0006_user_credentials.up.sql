@@ -0,0 +1,6 @@
+-- Plaintext copies of passwords used to be stored next to the hash.
+ALTER TABLE users DROP COLUMN IF EXISTS shown_password;
+
+-- Fails if two accounts share an email in different case; merge or remove
+-- the duplicates and run the migration again.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower ON users (lower(email));
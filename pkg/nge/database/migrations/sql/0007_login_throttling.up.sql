@@ -0,0 +1,18 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_login_count bigint NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_failed_login_at timestamptz;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until timestamptz;
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    email text,
+    user_id bigint,
+    ip text,
+    reason text
+);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_deleted_at ON login_attempts (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_email ON login_attempts (email);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_user_id ON login_attempts (user_id);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts (ip);
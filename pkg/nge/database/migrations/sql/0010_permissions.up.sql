@@ -0,0 +1,13 @@
+-- Permissions and default roles are filled in by 0015_default_roles.
+CREATE TABLE IF NOT EXISTS permissions (
+    id bigserial PRIMARY KEY,
+    name text,
+    description text
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_permissions_name ON permissions (name);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id bigint REFERENCES roles (id),
+    permission_id bigint REFERENCES permissions (id),
+    PRIMARY KEY (role_id, permission_id)
+);
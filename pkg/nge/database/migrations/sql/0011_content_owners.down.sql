@@ -0,0 +1,2 @@
+ALTER TABLE events DROP COLUMN IF EXISTS user_id;
+ALTER TABLE posts DROP COLUMN IF EXISTS author_id;
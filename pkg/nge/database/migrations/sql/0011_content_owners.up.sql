@@ -0,0 +1,7 @@
+-- Rows created before owners were recorded have none and can only be
+-- changed by moderators.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS author_id bigint;
+CREATE INDEX IF NOT EXISTS idx_posts_author_id ON posts (author_id);
+
+ALTER TABLE events ADD COLUMN IF NOT EXISTS user_id bigint;
+CREATE INDEX IF NOT EXISTS idx_events_user_id ON events (user_id);
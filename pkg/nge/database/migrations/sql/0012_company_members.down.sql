@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS company_owners (
+    company_id bigint REFERENCES companies (id),
+    user_id bigint REFERENCES users (id),
+    PRIMARY KEY (company_id, user_id)
+);
+
+-- Only owners survive the way back; other members are lost.
+INSERT INTO company_owners (company_id, user_id)
+SELECT company_id, user_id FROM company_members WHERE role = 'owner'
+ON CONFLICT DO NOTHING;
+
+DROP TABLE IF EXISTS company_members;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS company_members (
+    company_id bigint,
+    user_id bigint,
+    role text NOT NULL,
+    created_at timestamptz,
+    updated_at timestamptz,
+    PRIMARY KEY (company_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS idx_company_members_user_id ON company_members (user_id);
+
+-- Company owners used to be a plain many2many table.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'company_owners') THEN
+        INSERT INTO company_members (company_id, user_id, role, created_at, updated_at)
+        SELECT company_id, user_id, 'owner', now(), now() FROM company_owners
+        ON CONFLICT DO NOTHING;
+        DROP TABLE company_owners;
+    END IF;
+END
+$$;
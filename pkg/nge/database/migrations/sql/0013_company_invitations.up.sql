@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS company_invitations (
+    id bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    company_id bigint,
+    email text,
+    role text,
+    token_hash text,
+    invited_by_id bigint,
+    expires_at timestamptz,
+    accepted_at timestamptz,
+    declined_at timestamptz,
+    revoked_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_company_invitations_deleted_at ON company_invitations (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_company_invitations_company_id ON company_invitations (company_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_company_invitations_token_hash ON company_invitations (token_hash);
@@ -0,0 +1,3 @@
+-- Users and custom roles keep referring to the default roles and
+-- permissions, so rolling back leaves them in place.
+SELECT 1;
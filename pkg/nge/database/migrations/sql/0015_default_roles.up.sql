@@ -0,0 +1,44 @@
+-- Built-in permissions and default roles with their default grants, as
+-- listed in models/Role.go. Rows that already exist are left alone, so
+-- grants an admin changed before this migration stay as they are. Later
+-- permissions are added by their own migrations; `nge roles sync` restores
+-- the defaults on request.
+INSERT INTO permissions (name, description) VALUES
+    ('users:manage', 'Unlock users and assign roles'),
+    ('users:delete', 'Delete users'),
+    ('roles:manage', 'Create, edit and delete roles'),
+    ('posts:write', 'Create posts and edit own posts'),
+    ('posts:moderate', 'Edit and delete any post'),
+    ('events:write', 'Create events and edit own events'),
+    ('events:moderate', 'Edit and delete any event'),
+    ('companies:write', 'Create companies and edit own companies'),
+    ('companies:moderate', 'Edit and delete any company')
+ON CONFLICT (name) DO NOTHING;
+
+INSERT INTO roles (id, title) VALUES
+    (1, 'user'),
+    (2, 'admin'),
+    (3, 'moderator')
+ON CONFLICT (id) DO NOTHING;
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT grants.role_id, permissions.id
+FROM (VALUES
+    (1, 'posts:write'),
+    (1, 'events:write'),
+    (1, 'companies:write'),
+    (3, 'posts:write'),
+    (3, 'events:write'),
+    (3, 'companies:write'),
+    (3, 'posts:moderate'),
+    (3, 'events:moderate'),
+    (3, 'companies:moderate')
+) AS grants (role_id, name)
+JOIN permissions ON permissions.name = grants.name
+UNION ALL
+SELECT 2, permissions.id FROM permissions
+ON CONFLICT DO NOTHING;
+
+-- The default roles were inserted with explicit IDs, which does not move
+-- the sequence used for roles created later.
+SELECT setval(pg_get_serial_sequence('roles', 'id'), (SELECT MAX(id) FROM roles));
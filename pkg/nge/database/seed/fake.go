@@ -0,0 +1,261 @@
+package seed
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/Skapar/NGE/pkg/nge/models"
+)
+
+// batchSize is the number of rows inserted per statement.
+const batchSize = 500
+
+// Size is how many records of each kind Fake creates.
+type Size struct {
+	Users     int
+	Companies int
+	Posts     int
+	Events    int
+}
+
+// Sizes are the presets accepted by "nge seed --fake".
+var Sizes = map[string]Size{
+	"small":  {Users: 20, Companies: 5, Posts: 100, Events: 20},
+	"medium": {Users: 200, Companies: 40, Posts: 2000, Events: 200},
+	"large":  {Users: 5000, Companies: 500, Posts: 100000, Events: 5000},
+}
+
+// FakeOptions configures Fake.
+type FakeOptions struct {
+	Size
+	// Password is shared by every generated user so they can sign in.
+	Password string
+	// Seed makes the generated data reproducible; the same seed gives the
+	// same names and texts.
+	Seed int64
+}
+
+// Fake adds generated users, companies, posts and events and returns how
+// many of each were created. Generated users have verified emails at
+// example.com and the user role. Companies, posts and events belong to the
+// generated users, or to existing ones when no users are generated. Running
+// it again adds more data rather than failing on duplicates.
+func Fake(db *gorm.DB, opts FakeOptions) (Size, error) {
+	var created Size
+	g := generator{rng: rand.New(rand.NewSource(opts.Seed))}
+
+	userIDs, err := g.users(db, opts.Users, opts.Password)
+	if err != nil {
+		return created, fmt.Errorf("creating users: %w", err)
+	}
+	created.Users = len(userIDs)
+
+	if len(userIDs) == 0 && (opts.Companies > 0 || opts.Posts > 0 || opts.Events > 0) {
+		if err := db.Model(&models.User{}).Order("id").Limit(1000).Pluck("id", &userIDs).Error; err != nil {
+			return created, err
+		}
+		if len(userIDs) == 0 {
+			return created, errors.New("there are no users to own the generated data, generate some users too")
+		}
+	}
+
+	if created.Companies, err = g.companies(db, opts.Companies, userIDs); err != nil {
+		return created, fmt.Errorf("creating companies: %w", err)
+	}
+	if created.Posts, err = g.posts(db, opts.Posts, userIDs); err != nil {
+		return created, fmt.Errorf("creating posts: %w", err)
+	}
+	if created.Events, err = g.events(db, opts.Events, userIDs); err != nil {
+		return created, fmt.Errorf("creating events: %w", err)
+	}
+	return created, nil
+}
+
+type generator struct {
+	rng *rand.Rand
+}
+
+func (g generator) pick(words []string) string {
+	return words[g.rng.Intn(len(words))]
+}
+
+func (g generator) userID(ids []uint) uint {
+	return ids[g.rng.Intn(len(ids))]
+}
+
+// past returns a time up to maxAge ago.
+func (g generator) past(maxAge time.Duration) time.Time {
+	return time.Now().Add(-time.Duration(g.rng.Int63n(int64(maxAge))))
+}
+
+func (g generator) users(db *gorm.DB, n int, password string) ([]uint, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	if len(password) < models.MinPasswordLength {
+		return nil, models.ErrPasswordTooShort
+	}
+	// Every user gets the same password, so it is hashed once.
+	hashedPassword, err := models.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	// Numbering emails after the highest ID keeps them unique across runs,
+	// including users that were deleted since.
+	var lastID uint
+	if err := db.Unscoped().Model(&models.User{}).Select("COALESCE(MAX(id), 0)").Scan(&lastID).Error; err != nil {
+		return nil, err
+	}
+
+	users := make([]models.User, n)
+	for i := range users {
+		first, last := g.pick(firstNames), g.pick(lastNames)
+		createdAt := g.past(365 * 24 * time.Hour)
+		users[i] = models.User{
+			Username:        strings.ToLower(first + last[:1]),
+			Email:           strings.ToLower(fmt.Sprintf("%s.%s.%d@example.com", first, last, lastID+uint(i)+1)),
+			Password:        hashedPassword,
+			RoleID:          models.RoleUser,
+			EmailVerifiedAt: &createdAt,
+		}
+		users[i].CreatedAt = createdAt
+		users[i].UpdatedAt = createdAt
+	}
+	if err := db.CreateInBatches(&users, batchSize).Error; err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	return ids, nil
+}
+
+func (g generator) companies(db *gorm.DB, n int, userIDs []uint) (int, error) {
+	if n <= 0 {
+		return 0, nil
+	}
+
+	companies := make([]models.Company, n)
+	for i := range companies {
+		start := g.past(3 * 365 * 24 * time.Hour)
+		name := fmt.Sprintf("%s %s %s", g.pick(companyAdjectives), g.pick(companyNouns), g.pick(companySuffixes))
+
+		// An owner and up to four other members, each user at most once.
+		owner := g.userID(userIDs)
+		members := []models.CompanyMember{{UserID: owner, Role: models.CompanyRoleOwner}}
+		seen := map[uint]bool{owner: true}
+		for j := g.rng.Intn(5); j > 0; j-- {
+			userID := g.userID(userIDs)
+			if seen[userID] {
+				continue
+			}
+			seen[userID] = true
+			role := []string{models.CompanyRoleAdmin, models.CompanyRoleMember, models.CompanyRoleMember, models.CompanyRoleViewer}[g.rng.Intn(4)]
+			members = append(members, models.CompanyMember{UserID: userID, Role: role})
+		}
+
+		companies[i] = models.Company{
+			Name:        name,
+			Description: fmt.Sprintf("%s builds %s for %s.", name, g.pick(companyProducts), g.pick(companyMarkets)),
+			StartDate:   start,
+			EndDate:     start.AddDate(1+g.rng.Intn(5), 0, 0),
+			Members:     members,
+		}
+	}
+	if err := db.CreateInBatches(&companies, batchSize).Error; err != nil {
+		return 0, err
+	}
+	return len(companies), nil
+}
+
+func (g generator) posts(db *gorm.DB, n int, userIDs []uint) (int, error) {
+	if n <= 0 {
+		return 0, nil
+	}
+
+	posts := make([]models.Post, n)
+	for i := range posts {
+		text := fmt.Sprintf("Day %d: %s %s %s", 1+g.rng.Intn(999), g.pick(postOpenings), g.pick(postTopics), g.pick(postClosings))
+		createdAt := g.past(180 * 24 * time.Hour)
+		posts[i] = models.Post{Text: text, AuthorID: g.userID(userIDs)}
+		posts[i].CreatedAt = createdAt
+		posts[i].UpdatedAt = createdAt
+	}
+
+	// Post texts are unique and the phrases below can repeat, so duplicates
+	// are skipped.
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).CreateInBatches(&posts, batchSize)
+	return int(result.RowsAffected), result.Error
+}
+
+func (g generator) events(db *gorm.DB, n int, userIDs []uint) (int, error) {
+	if n <= 0 {
+		return 0, nil
+	}
+
+	events := make([]models.Event, n)
+	for i := range events {
+		// Half of the events are in the past, half in the coming months.
+		date := time.Now().AddDate(0, 0, g.rng.Intn(360)-180).Truncate(time.Hour)
+		events[i] = models.Event{
+			Date:        date,
+			Description: fmt.Sprintf("%s: %s", g.pick(eventKinds), g.pick(postTopics)),
+			UserID:      g.userID(userIDs),
+		}
+	}
+	if err := db.CreateInBatches(&events, batchSize).Error; err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+var (
+	firstNames = []string{
+		"Aigerim", "Alikhan", "Amina", "Arman", "Aruzhan", "Daniyar", "Dana", "Erlan", "Gulnara", "Ilyas",
+		"Kamila", "Madina", "Nurlan", "Saule", "Timur", "Zarina", "Alex", "Maria", "John", "Sofia",
+		"David", "Emma", "Lucas", "Olivia", "Noah", "Mia", "Leo", "Anna", "Omar", "Yuki",
+	}
+	lastNames = []string{
+		"Akhmetov", "Bekova", "Dzhaksybekov", "Iskakova", "Karimov", "Nurlanova", "Omarov", "Sadykova",
+		"Seitkali", "Tulegenov", "Smith", "Garcia", "Muller", "Rossi", "Novak", "Kim", "Tanaka", "Silva",
+	}
+
+	companyAdjectives = []string{"Bright", "Steppe", "Silk", "Blue", "Swift", "Open", "Clever", "Green", "Nomad", "Golden"}
+	companyNouns      = []string{"Road", "Labs", "Bridge", "Forge", "Harbor", "Orbit", "Pixel", "Field", "Spark", "Stack"}
+	companySuffixes   = []string{"Technologies", "Studio", "Ventures", "Systems", "Collective", "Group"}
+	companyProducts   = []string{
+		"payment tools", "learning apps", "logistics software", "farm sensors", "booking platforms",
+		"developer tools", "health trackers", "marketplaces", "energy monitors", "translation services",
+	}
+	companyMarkets = []string{
+		"small businesses", "students", "farmers", "clinics", "online shops",
+		"freelancers", "city governments", "travellers", "schools", "restaurants",
+	}
+
+	postOpenings = []string{
+		"Just shipped", "Looking for feedback on", "We are hiring for", "Lessons learned from",
+		"Three months into", "Anyone else working on", "Excited to announce", "Thoughts on",
+		"Our team is exploring", "Struggling with",
+	}
+	postTopics = []string{
+		"our first MVP", "pricing for B2B customers", "a pitch deck", "customer interviews",
+		"fundraising in Central Asia", "remote team culture", "product-market fit", "a mobile checkout",
+		"growth experiments", "hiring the first engineer", "a university startup club", "open source tooling",
+	}
+	postClosings = []string{
+		"- comments welcome!", "and it went better than expected.", "Happy to share notes.",
+		"Who wants to grab a coffee?", "Would love some mentors.", "What would you do?",
+		"More updates soon.", "DM me if interested.",
+	}
+
+	eventKinds = []string{"Meetup", "Workshop", "Pitch night", "Hackathon", "Demo day", "Webinar", "Office hours"}
+)
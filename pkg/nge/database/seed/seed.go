@@ -0,0 +1,37 @@
+// Package seed fills a database with the data NGE needs to run, and with
+// generated sample data for local development, demos and load tests.
+package seed
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+
+	"github.com/Skapar/NGE/pkg/nge/models"
+)
+
+// Admin is the administrator account created by Defaults.
+type Admin struct {
+	Username string
+	Email    string
+	Password string
+}
+
+// Defaults creates the built-in roles and permissions and, when admin.Email
+// is set, the administrator. It can be run any number of times: an existing
+// administrator is left as it is. It reports whether the administrator was
+// created.
+func Defaults(db *gorm.DB, admin Admin) (bool, error) {
+	if err := models.SyncRoles(db); err != nil {
+		return false, err
+	}
+	if admin.Email == "" {
+		return false, nil
+	}
+
+	_, err := models.CreateAdmin(db, admin.Username, admin.Email, admin.Password)
+	if errors.Is(err, models.ErrEmailTaken) {
+		return false, nil
+	}
+	return err == nil, err
+}
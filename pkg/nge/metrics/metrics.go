@@ -0,0 +1,169 @@
+// Package metrics defines the Prometheus metrics of NGE: HTTP traffic,
+// database queries and connection pool, and business events.
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"gorm.io/gorm"
+)
+
+const namespace = "nge"
+
+// Metrics holds every collector, registered on its own registry so that
+// tests and commands can create as many as they like.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	HTTPRequests        *prometheus.CounterVec
+	HTTPRequestDuration *prometheus.HistogramVec
+	HTTPInFlight        prometheus.Gauge
+
+	DBQueryDuration *prometheus.HistogramVec
+	DBQueryErrors   *prometheus.CounterVec
+
+	Signups          prometheus.Counter
+	PostsCreated     prometheus.Counter
+	EventsCreated    prometheus.Counter
+	CompaniesCreated prometheus.Counter
+}
+
+// New creates the collectors and registers them, together with the Go
+// runtime and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+
+		HTTPRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by method, route template and status code.",
+		}, []string{"method", "route", "status"}),
+		HTTPRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time spent serving HTTP requests by method, route template and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		HTTPInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "http_requests_in_flight",
+			Help:      "HTTP requests being served.",
+		}),
+
+		DBQueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "db_query_duration_seconds",
+			Help:      "Duration of database queries by operation and table.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"operation", "table"}),
+		DBQueryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "db_query_errors_total",
+			Help:      "Failed database queries by operation and table. Queries finding no rows are not errors.",
+		}, []string{"operation", "table"}),
+
+		Signups: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "signups_total",
+			Help:      "Accounts created through /signup.",
+		}),
+		PostsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "posts_created_total",
+			Help:      "Posts created.",
+		}),
+		EventsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "events_created_total",
+			Help:      "Events created.",
+		}),
+		CompaniesCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "companies_created_total",
+			Help:      "Companies created.",
+		}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.HTTPRequests, m.HTTPRequestDuration, m.HTTPInFlight,
+		m.DBQueryDuration, m.DBQueryErrors,
+		m.Signups, m.PostsCreated, m.EventsCreated, m.CompaniesCreated,
+	)
+	return m
+}
+
+// RegisterDBStats exports the statistics of the connection pool: open, idle
+// and in-use connections, waits and closed connections.
+func (m *Metrics) RegisterDBStats(db *sql.DB, name string) error {
+	return m.Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// GormPlugin returns a gorm plugin that times every query.
+func (m *Metrics) GormPlugin() gorm.Plugin {
+	return gormPlugin{m}
+}
+
+type gormPlugin struct {
+	metrics *Metrics
+}
+
+const startKey = "metrics:start"
+
+// registerer is the part of gorm's callback builder the plugin uses.
+type registerer interface {
+	Register(name string, fn func(*gorm.DB)) error
+}
+
+func (gormPlugin) Name() string {
+	return "nge:metrics"
+}
+
+func (p gormPlugin) Initialize(db *gorm.DB) error {
+	before := func(db *gorm.DB) {
+		db.InstanceSet(startKey, time.Now())
+	}
+	after := func(operation string) func(db *gorm.DB) {
+		return func(db *gorm.DB) {
+			value, ok := db.InstanceGet(startKey)
+			if !ok {
+				return
+			}
+			table := db.Statement.Table
+			if table == "" {
+				table = "unknown"
+			}
+			p.metrics.DBQueryDuration.WithLabelValues(operation, table).Observe(time.Since(value.(time.Time)).Seconds())
+			if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+				p.metrics.DBQueryErrors.WithLabelValues(operation, table).Inc()
+			}
+		}
+	}
+
+	callbacks := db.Callback()
+	for _, hook := range []struct {
+		operation     string
+		before, after registerer
+	}{
+		{"create", callbacks.Create().Before("gorm:create"), callbacks.Create().After("gorm:create")},
+		{"query", callbacks.Query().Before("gorm:query"), callbacks.Query().After("gorm:query")},
+		{"update", callbacks.Update().Before("gorm:update"), callbacks.Update().After("gorm:update")},
+		{"delete", callbacks.Delete().Before("gorm:delete"), callbacks.Delete().After("gorm:delete")},
+		{"row", callbacks.Row().Before("gorm:row"), callbacks.Row().After("gorm:row")},
+		{"raw", callbacks.Raw().Before("gorm:raw"), callbacks.Raw().After("gorm:raw")},
+	} {
+		if err := hook.before.Register("metrics:before_"+hook.operation, before); err != nil {
+			return err
+		}
+		if err := hook.after.Register("metrics:after_"+hook.operation, after(hook.operation)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKeyPrefix marks NGE API keys so they can be told apart from JWTs in the
+// Authorization header and recognised by secret scanners.
+const APIKeyPrefix = "nge_"
+
+// apiKeyLastUsedGranularity limits how often last_used_at is written for a
+// key that is used continuously.
+const apiKeyLastUsedGranularity = time.Minute
+
+var (
+	ErrInvalidAPIKey  = Unauthorized("invalid_api_key", "invalid, revoked or expired API key")
+	ErrInvalidScope   = Invalid("invalid_scope", "unknown API key scope")
+	ErrAPIKeyNotFound = NotFound("api_key_not_found", "API key not found")
+)
+
+// APIKey is a long-lived credential for scripts. Only the SHA-256 hash of the
+// key is stored; the key itself is shown once when it is created.
+//
+// Scopes are permission names. A key can only use permissions that are both
+// in its scopes and granted by its owner's role; a key without scopes can do
+// everything its owner can.
+type APIKey struct {
+	gorm.Model
+	UserID     uint `gorm:"index"`
+	Name       string
+	Prefix     string
+	KeyHash    string `gorm:"uniqueIndex"`
+	Scopes     string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+type APIKeyResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// CreatedAPIKeyResponse is returned once, when the key is created.
+type CreatedAPIKeyResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
+
+func (k APIKey) ScopeList() []string {
+	if k.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(k.Scopes, ",")
+}
+
+func (k APIKey) Response() APIKeyResponse {
+	return APIKeyResponse{
+		ID:         k.ID,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     k.ScopeList(),
+		ExpiresAt:  k.ExpiresAt,
+		LastUsedAt: k.LastUsedAt,
+		RevokedAt:  k.RevokedAt,
+		CreatedAt:  k.CreatedAt,
+	}
+}
+
+func validateScopes(scopes []string) (string, error) {
+	seen := map[string]bool{}
+	for _, scope := range scopes {
+		if !IsPermission(scope) {
+			return "", fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+		seen[scope] = true
+	}
+
+	unique := make([]string, 0, len(seen))
+	for scope := range seen {
+		unique = append(unique, scope)
+	}
+	sort.Strings(unique)
+	return strings.Join(unique, ","), nil
+}
+
+// CreateAPIKey issues a new key for the user and returns it together with
+// the plaintext key, which cannot be retrieved again.
+func CreateAPIKey(db *gorm.DB, userID uint, name string, scopes []string, expiresAt *time.Time) (*CreatedAPIKeyResponse, error) {
+	scopeList, err := validateScopes(scopes)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	key := APIKeyPrefix + secret
+
+	apiKey := APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    key[:len(APIKeyPrefix)+8],
+		KeyHash:   hashToken(key),
+		Scopes:    scopeList,
+		ExpiresAt: expiresAt,
+	}
+	if err := db.Create(&apiKey).Error; err != nil {
+		return nil, err
+	}
+
+	return &CreatedAPIKeyResponse{APIKeyResponse: apiKey.Response(), Key: key}, nil
+}
+
+// GetAPIKeys lists all keys of the user, including revoked ones.
+func GetAPIKeys(db *gorm.DB, userID uint) ([]APIKey, error) {
+	var keys []APIKey
+	err := db.Where("user_id = ?", userID).Order("id").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeAPIKey revokes one of the user's keys.
+func RevokeAPIKey(db *gorm.DB, userID, keyID uint) error {
+	result := db.Model(&APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", keyID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// RevokeAPIKeys revokes every active key of the user, or of every user when
+// userID is 0, and returns how many were revoked.
+func RevokeAPIKeys(db *gorm.DB, userID uint) (int64, error) {
+	query := db.Model(&APIKey{}).Where("revoked_at IS NULL")
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	result := query.Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// AuthenticateAPIKey returns the active key matching the plaintext key and
+// records that it was used. Keys of deleted or disabled users never match.
+func AuthenticateAPIKey(db *gorm.DB, key string) (*APIKey, error) {
+	var apiKey APIKey
+	err := db.Joins("JOIN users ON users.id = api_keys.user_id AND users.deleted_at IS NULL AND users.disabled_at IS NULL").
+		Where("api_keys.key_hash = ?", hashToken(key)).
+		First(&apiKey).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if apiKey.RevokedAt != nil || (apiKey.ExpiresAt != nil && now.After(*apiKey.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyLastUsedGranularity {
+		if err := db.Model(&apiKey).Update("last_used_at", now).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &apiKey, nil
+}
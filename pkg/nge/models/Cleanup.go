@@ -0,0 +1,35 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PurgeExpired permanently deletes sessions, refresh tokens, MFA challenges,
+// password reset tokens, OIDC login states and company invitations that
+// expired before the given time, and returns how many rows were deleted.
+// None of them can be used once expired. Login attempts older than
+// attemptRetention are deleted too, unless it is zero.
+func PurgeExpired(db *gorm.DB, before time.Time, attemptRetention time.Duration) (int64, error) {
+	var deleted int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&RefreshToken{}, &Session{}, &MFAChallenge{}, &PasswordResetToken{}, &OIDCLoginState{}, &CompanyInvitation{}} {
+			result := tx.Unscoped().Where("expires_at < ?", before).Delete(model)
+			if result.Error != nil {
+				return result.Error
+			}
+			deleted += result.RowsAffected
+		}
+
+		if attemptRetention > 0 {
+			result := tx.Unscoped().Where("created_at < ?", before.Add(-attemptRetention)).Delete(&LoginAttempt{})
+			if result.Error != nil {
+				return result.Error
+			}
+			deleted += result.RowsAffected
+		}
+		return nil
+	})
+	return deleted, err
+}
@@ -9,55 +9,59 @@ import (
 // Company struct represents a company with its details
 type Company struct {
 	gorm.Model
-	ID          uint      `json:"id" gorm:"unique;primaryKey;autoIncrement"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	StartDate   time.Time `json:"start_date"`
-	EndDate     time.Time `json:"end_date"`
-	Owners      []*User   `gorm:"many2many:company_owners;" json:"owners"`
+	ID          uint            `json:"id" gorm:"unique;primaryKey;autoIncrement"`
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	StartDate   time.Time       `json:"start_date"`
+	EndDate     time.Time       `json:"end_date"`
+	Members     []CompanyMember `json:"members"`
 }
 
-// CreateCompany creates a new company
-func CreateCompany(db *gorm.DB, name string, description string, startDate time.Time, endDate time.Time, owners []*User) error {
+// CreateCompany creates a new company owned by ownerID
+func CreateCompany(db *gorm.DB, name string, description string, startDate time.Time, endDate time.Time, ownerID uint) error {
 	company := Company{
 		Name:        name,
 		Description: description,
 		StartDate:   startDate,
 		EndDate:     endDate,
-		Owners:      owners,
+		Members:     []CompanyMember{{UserID: ownerID, Role: CompanyRoleOwner}},
 	}
 	return db.Create(&company).Error
 }
 
-// DeleteCompany deletes a company by ID
+// DeleteCompany deletes a company by ID together with its memberships
 func DeleteCompany(db *gorm.DB, companyID uint) error {
-	result := db.Delete(&Company{}, companyID)
-	return result.Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("company_id = ?", companyID).Delete(&CompanyMember{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&Company{}, companyID).Error
+	})
 }
 
 // UpdateCompany updates a company's details
-func UpdateCompany(db *gorm.DB, companyID uint, name string, description string, startDate time.Time, endDate time.Time, owners []*User) error {
-	result := db.Model(&Company{}).Where("id = ?", companyID).Updates(Company{Name: name, Description: description, StartDate: startDate, EndDate: endDate, Owners: owners})
+func UpdateCompany(db *gorm.DB, companyID uint, name string, description string, startDate time.Time, endDate time.Time) error {
+	result := db.Model(&Company{}).Where("id = ?", companyID).Updates(Company{Name: name, Description: description, StartDate: startDate, EndDate: endDate})
 	return result.Error
 }
 
 // GetCompanyByID retrieves a company by ID
 func GetCompanyByID(db *gorm.DB, companyID uint) (Company, error) {
 	var company Company
-	result := db.Preload("Owners").First(&company, companyID)
-	return company, result.Error
+	result := db.Preload("Members").First(&company, companyID)
+	return company, notFound(result.Error, ErrCompanyNotFound)
 }
 
 // GetAllCompanies retrieves all companies
 func GetAllCompanies(db *gorm.DB) ([]Company, error) {
 	var companies []Company
-	result := db.Preload("Owners").Find(&companies)
+	result := db.Preload("Members").Find(&companies)
 	return companies, result.Error
 }
 
-// GetCompaniesByUserID retrieves companies owned by a user
+// GetCompaniesByUserID retrieves companies the user is a member of
 func GetCompaniesByUserID(db *gorm.DB, userID uint) ([]Company, error) {
 	var companies []Company
-	result := db.Preload("Owners").Joins("JOIN company_owners ON companies.id = company_owners.company_id").Where("company_owners.user_id = ?", userID).Find(&companies)
+	result := db.Preload("Members").Joins("JOIN company_members ON companies.id = company_members.company_id").Where("company_members.user_id = ?", userID).Find(&companies)
 	return companies, result.Error
 }
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const CompanyInvitationTTL = 7 * 24 * time.Hour
+
+var (
+	ErrInvalidInvitation       = Invalid("invalid_invitation", "invitation is invalid, has expired or was already answered")
+	ErrInvitationEmailMismatch = Forbidden("invitation_email_mismatch", "invitation was sent to a different email address")
+	ErrInvitationNotFound      = NotFound("invitation_not_found", "invitation not found")
+)
+
+// CompanyInvitation invites an email address to join a company with a role.
+// The invitee gets a single-use link; only the SHA-256 hash of its token is
+// stored.
+type CompanyInvitation struct {
+	gorm.Model
+	CompanyID   uint       `json:"company_id" gorm:"index"`
+	Email       string     `json:"email"`
+	Role        string     `json:"role"`
+	TokenHash   string     `json:"-" gorm:"uniqueIndex"`
+	InvitedByID uint       `json:"invited_by_id"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	AcceptedAt  *time.Time `json:"accepted_at"`
+	DeclinedAt  *time.Time `json:"declined_at"`
+	RevokedAt   *time.Time `json:"revoked_at"`
+}
+
+// pendingInvitations restricts a query to invitations that can still be
+// answered.
+func pendingInvitations(db *gorm.DB) *gorm.DB {
+	return db.Where("accepted_at IS NULL AND declined_at IS NULL AND revoked_at IS NULL AND expires_at > ?", time.Now())
+}
+
+// CreateCompanyInvitation invites email to the company and returns the token
+// to send to it. A pending invitation of the same address to the same company
+// is replaced.
+func CreateCompanyInvitation(db *gorm.DB, companyID, invitedByID uint, email, role string) (string, *CompanyInvitation, error) {
+	if !IsCompanyRole(role) {
+		return "", nil, ErrInvalidCompanyRole
+	}
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return "", nil, err
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	invitation := CompanyInvitation{
+		CompanyID:   companyID,
+		Email:       email,
+		Role:        role,
+		TokenHash:   hashToken(token),
+		InvitedByID: invitedByID,
+		ExpiresAt:   time.Now().Add(CompanyInvitationTTL),
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var members int64
+		err := tx.Model(&CompanyMember{}).
+			Joins("JOIN users ON users.id = company_members.user_id").
+			Where("company_members.company_id = ? AND lower(users.email) = ?", companyID, email).
+			Count(&members).Error
+		if err != nil {
+			return err
+		}
+		if members > 0 {
+			return ErrAlreadyMember
+		}
+
+		err = pendingInvitations(tx.Model(&CompanyInvitation{})).
+			Where("company_id = ? AND email = ?", companyID, email).
+			Update("revoked_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Create(&invitation).Error
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return token, &invitation, nil
+}
+
+// GetPendingCompanyInvitations lists the invitations of the company that can
+// still be answered.
+func GetPendingCompanyInvitations(db *gorm.DB, companyID uint) ([]CompanyInvitation, error) {
+	var invitations []CompanyInvitation
+	err := pendingInvitations(db).Where("company_id = ?", companyID).Order("id").Find(&invitations).Error
+	return invitations, err
+}
+
+// RevokeCompanyInvitation cancels a pending invitation of the company.
+func RevokeCompanyInvitation(db *gorm.DB, companyID, invitationID uint) error {
+	result := pendingInvitations(db.Model(&CompanyInvitation{})).
+		Where("id = ? AND company_id = ?", invitationID, companyID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvitationNotFound
+	}
+	return nil
+}
+
+func findPendingInvitation(tx *gorm.DB, token string) (*CompanyInvitation, error) {
+	var invitation CompanyInvitation
+	err := pendingInvitations(tx).Where("token_hash = ?", hashToken(token)).First(&invitation).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidInvitation
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &invitation, nil
+}
+
+// answerInvitation marks the invitation as answered in column, failing if
+// another request answered it first.
+func answerInvitation(tx *gorm.DB, invitation *CompanyInvitation, column string) error {
+	result := pendingInvitations(tx.Model(&CompanyInvitation{})).
+		Where("id = ?", invitation.ID).
+		Update(column, time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidInvitation
+	}
+	return nil
+}
+
+// AcceptCompanyInvitation adds the user to the company with the invited
+// role. The user's email must be the invited address.
+func AcceptCompanyInvitation(db *gorm.DB, token string, user User) (*CompanyMember, error) {
+	var member *CompanyMember
+	err := db.Transaction(func(tx *gorm.DB) error {
+		invitation, err := findPendingInvitation(tx, token)
+		if err != nil {
+			return err
+		}
+
+		email, err := NormalizeEmail(user.Email)
+		if err != nil || email != invitation.Email {
+			return ErrInvitationEmailMismatch
+		}
+
+		if err := answerInvitation(tx, invitation, "accepted_at"); err != nil {
+			return err
+		}
+		member, err = AddCompanyMember(tx, invitation.CompanyID, user.ID, invitation.Role)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// DeclineCompanyInvitation turns the invitation down. The token is enough;
+// the invitee does not need an account.
+func DeclineCompanyInvitation(db *gorm.DB, token string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		invitation, err := findPendingInvitation(tx, token)
+		if err != nil {
+			return err
+		}
+		return answerInvitation(tx, invitation, "declined_at")
+	})
+}
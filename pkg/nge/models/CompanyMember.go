@@ -0,0 +1,197 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Roles inside a company, from least to most privileged. They are separate
+// from the global role in User.RoleID.
+const (
+	CompanyRoleViewer = "viewer"
+	CompanyRoleMember = "member"
+	CompanyRoleAdmin  = "admin"
+	CompanyRoleOwner  = "owner"
+)
+
+var companyRoleRank = map[string]int{
+	CompanyRoleViewer: 1,
+	CompanyRoleMember: 2,
+	CompanyRoleAdmin:  3,
+	CompanyRoleOwner:  4,
+}
+
+var (
+	ErrInvalidCompanyRole = Invalid("invalid_company_role", "company role must be one of owner, admin, member or viewer").WithField("role", "must be one of owner, admin, member or viewer")
+	ErrAlreadyMember      = Conflict("already_member", "user is already a member of this company")
+	ErrNotMember          = NotFound("not_member", "user is not a member of this company")
+	ErrLastOwner          = Conflict("last_owner", "a company must keep at least one owner")
+	ErrInvalidTransfer    = Invalid("invalid_transfer", "ownership can only be transferred by an owner to another member")
+)
+
+// CompanyMember gives a user a role inside a company.
+type CompanyMember struct {
+	CompanyID uint      `json:"company_id" gorm:"primaryKey"`
+	UserID    uint      `json:"user_id" gorm:"primaryKey;index"`
+	Role      string    `json:"role" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func IsCompanyRole(role string) bool {
+	_, ok := companyRoleRank[role]
+	return ok
+}
+
+// CompanyRoleAtLeast reports whether role grants at least the rights of min.
+// An empty role, meaning no membership, grants nothing.
+func CompanyRoleAtLeast(role, min string) bool {
+	return role != "" && companyRoleRank[role] >= companyRoleRank[min]
+}
+
+// GetCompanyRole returns the user's role in the company, or "" when the user
+// is not a member. It returns ErrCompanyNotFound when the company does not
+// exist.
+func GetCompanyRole(db *gorm.DB, companyID, userID uint) (string, error) {
+	if err := db.Select("id").First(&Company{}, companyID).Error; err != nil {
+		return "", notFound(err, ErrCompanyNotFound)
+	}
+
+	var member CompanyMember
+	err := db.Where("company_id = ? AND user_id = ?", companyID, userID).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	return member.Role, err
+}
+
+func GetCompanyMembers(db *gorm.DB, companyID uint) ([]CompanyMember, error) {
+	var members []CompanyMember
+	err := db.Where("company_id = ?", companyID).Order("created_at").Find(&members).Error
+	return members, err
+}
+
+// AddCompanyMember adds an existing user to the company with the given role.
+func AddCompanyMember(db *gorm.DB, companyID, userID uint, role string) (*CompanyMember, error) {
+	if !IsCompanyRole(role) {
+		return nil, ErrInvalidCompanyRole
+	}
+	if err := db.Select("id").First(&User{}, userID).Error; err != nil {
+		return nil, notFound(err, ErrUserNotFound)
+	}
+
+	member := CompanyMember{CompanyID: companyID, UserID: userID, Role: role}
+	result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&member)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrAlreadyMember
+	}
+	return &member, nil
+}
+
+// lockCompanyOwners locks the company's owner rows so concurrent changes
+// cannot remove the last owner between the check and the write.
+func lockCompanyOwners(tx *gorm.DB, companyID uint) (int, error) {
+	var owners []CompanyMember
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("company_id = ? AND role = ?", companyID, CompanyRoleOwner).
+		Find(&owners).Error
+	return len(owners), err
+}
+
+func getCompanyMember(tx *gorm.DB, companyID, userID uint) (*CompanyMember, error) {
+	var member CompanyMember
+	err := tx.Where("company_id = ? AND user_id = ?", companyID, userID).First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotMember
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// UpdateCompanyMemberRole changes a member's role. The last owner cannot be
+// demoted.
+func UpdateCompanyMemberRole(db *gorm.DB, companyID, userID uint, role string) (*CompanyMember, error) {
+	if !IsCompanyRole(role) {
+		return nil, ErrInvalidCompanyRole
+	}
+
+	var member *CompanyMember
+	err := db.Transaction(func(tx *gorm.DB) error {
+		owners, err := lockCompanyOwners(tx, companyID)
+		if err != nil {
+			return err
+		}
+		member, err = getCompanyMember(tx, companyID, userID)
+		if err != nil {
+			return err
+		}
+		if member.Role == CompanyRoleOwner && role != CompanyRoleOwner && owners <= 1 {
+			return ErrLastOwner
+		}
+
+		member.Role = role
+		return tx.Model(member).Update("role", role).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// RemoveCompanyMember removes a user from the company. The last owner cannot
+// be removed.
+func RemoveCompanyMember(db *gorm.DB, companyID, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		owners, err := lockCompanyOwners(tx, companyID)
+		if err != nil {
+			return err
+		}
+		member, err := getCompanyMember(tx, companyID, userID)
+		if err != nil {
+			return err
+		}
+		if member.Role == CompanyRoleOwner && owners <= 1 {
+			return ErrLastOwner
+		}
+
+		return tx.Where("company_id = ? AND user_id = ?", companyID, userID).Delete(&CompanyMember{}).Error
+	})
+}
+
+// TransferCompanyOwnership makes another member an owner and turns the
+// current owner into an admin.
+func TransferCompanyOwnership(db *gorm.DB, companyID, fromUserID, toUserID uint) error {
+	if fromUserID == toUserID {
+		return ErrInvalidTransfer
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if _, err := lockCompanyOwners(tx, companyID); err != nil {
+			return err
+		}
+		from, err := getCompanyMember(tx, companyID, fromUserID)
+		if err != nil {
+			return err
+		}
+		if from.Role != CompanyRoleOwner {
+			return ErrInvalidTransfer
+		}
+		to, err := getCompanyMember(tx, companyID, toUserID)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(to).Update("role", CompanyRoleOwner).Error; err != nil {
+			return err
+		}
+		return tx.Model(from).Update("role", CompanyRoleAdmin).Error
+	})
+}
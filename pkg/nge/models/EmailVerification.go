@@ -0,0 +1,117 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"gorm.io/gorm"
+)
+
+const (
+	EmailVerificationTTL      = 24 * time.Hour
+	emailVerificationAudience = "email-verification"
+)
+
+var (
+	ErrInvalidVerificationToken = Invalid("invalid_verification_token", "verification link is invalid or has expired")
+	ErrEmailAlreadyVerified     = Conflict("email_already_verified", "email address is already verified")
+)
+
+// ErrVerificationThrottled is returned, with how long to wait as RetryAfter,
+// when a verification email was sent too recently to send another one.
+var ErrVerificationThrottled = TooManyRequests("verification_throttled", "a verification email was sent recently, please wait before requesting another one")
+
+type emailVerificationClaims struct {
+	UserID uint   `json:"uid"`
+	Email  string `json:"email"`
+	jwt.StandardClaims
+}
+
+// GenerateEmailVerificationToken returns a signed token that proves
+// ownership of the user's current email address.
+func GenerateEmailVerificationToken(user User) (string, error) {
+	claims := &emailVerificationClaims{
+		UserID: user.ID,
+		Email:  user.Email,
+		StandardClaims: jwt.StandardClaims{
+			Audience:  emailVerificationAudience,
+			ExpiresAt: time.Now().Add(EmailVerificationTTL).Unix(),
+		},
+	}
+	return signClaims(claims)
+}
+
+// MarkVerificationSent records that a verification email is about to be
+// sent, refusing when the previous one went out less than interval ago. The
+// check and the update are one statement, so of two concurrent requests only
+// one gets to send.
+func MarkVerificationSent(db *gorm.DB, user User, interval time.Duration) error {
+	if user.EmailVerified() {
+		return ErrEmailAlreadyVerified
+	}
+
+	now := time.Now()
+	result := db.Model(&User{}).
+		Where("id = ? AND email_verified_at IS NULL AND (verification_sent_at IS NULL OR verification_sent_at <= ?)", user.ID, now.Add(-interval)).
+		Update("verification_sent_at", now)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected > 0 {
+		return nil
+	}
+
+	var current User
+	if err := db.Select("id", "email_verified_at", "verification_sent_at").First(&current, user.ID).Error; err != nil {
+		return notFound(err, ErrUserNotFound)
+	}
+	if current.EmailVerified() {
+		return ErrEmailAlreadyVerified
+	}
+	wait := time.Duration(0)
+	if current.VerificationSentAt != nil {
+		wait = current.VerificationSentAt.Add(interval).Sub(now)
+	}
+	return ErrVerificationThrottled.WithRetryAfter(wait)
+}
+
+// VerifyEmail validates the token and marks the email address as verified.
+// Tokens issued for an address the user no longer has are rejected.
+func VerifyEmail(db *gorm.DB, token string) (*User, error) {
+	claims := &emailVerificationClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, verificationKey)
+	if err != nil || !parsed.Valid || !claims.VerifyAudience(emailVerificationAudience, true) {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	var user User
+	if err := db.First(&user, claims.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidVerificationToken
+		}
+		return nil, err
+	}
+	if user.Email != claims.Email {
+		return nil, ErrInvalidVerificationToken
+	}
+	if user.EmailVerified() {
+		return &user, nil
+	}
+
+	now := time.Now()
+	if err := db.Model(&user).Update("email_verified_at", now).Error; err != nil {
+		return nil, err
+	}
+	user.EmailVerifiedAt = &now
+	return &user, nil
+}
+
+// IsEmailVerified reports whether the user has confirmed their email address.
+func IsEmailVerified(db *gorm.DB, userID uint) (bool, error) {
+	var user User
+	if err := db.Select("id", "email_verified_at").First(&user, userID).Error; err != nil {
+		return false, err
+	}
+	return user.EmailVerified(), nil
+}
@@ -12,32 +12,42 @@ type Event struct {
 	Id          uint      `json:"id" gorm:"unique;primaryKey;autoIncrement"`
 	Date        time.Time `json:"date"`
 	Description string    `json:"description" `
-	UserID      uint      `json:"user_id" `
+	UserID      uint      `json:"user_id" gorm:"index"`
 }
 
-
-func AddEvent(db *gorm.DB, date time.Time, description string) error {
+func AddEvent(db *gorm.DB, userID uint, date time.Time, description string) error {
 	event := Event{
 		Date:        date,
 		Description: description,
+		UserID:      userID,
 	}
 	return db.Create(&event).Error
 }
 
 func DeleteEvent(db *gorm.DB, eventID uint) error {
 	result := db.Delete(&Event{}, eventID)
-	return result.Error 
+	return result.Error
 }
 
 func UpdateEvent(db *gorm.DB, eventID uint, newDate time.Time, newDescription string) error {
 	result := db.Model(&Event{}).Where("id = ?", eventID).Updates(Event{Date: newDate, Description: newDescription})
-	return result.Error 
+	return result.Error
 }
 
 func GetEventByID(db *gorm.DB, eventID uint) (Event, error) {
 	var event Event
 	result := db.First(&event, eventID)
-	return event, result.Error
+	return event, notFound(result.Error, ErrEventNotFound)
+}
+
+// IsEventOwner reports whether the user created the event. It returns
+// ErrEventNotFound when the event does not exist.
+func IsEventOwner(db *gorm.DB, eventID, userID uint) (bool, error) {
+	event, err := GetEventByID(db, eventID)
+	if err != nil {
+		return false, err
+	}
+	return event.UserID == userID, nil
 }
 
 func GetAllEvents(db *gorm.DB, userID uint) ([]Event, error) {
@@ -48,4 +58,4 @@ func GetAllEvents(db *gorm.DB, userID uint) ([]Event, error) {
 	}
 	result := query.Find(&events)
 	return events, result.Error
-}
\ No newline at end of file
+}
@@ -0,0 +1,214 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const OIDCLoginStateTTL = 10 * time.Minute
+
+var (
+	ErrInvalidLoginState     = Invalid("invalid_login_state", "sign-in request is invalid or has expired, start again")
+	ErrIdentityLinked        = Conflict("identity_linked", "this external account is already linked to another user")
+	ErrIdentityEmailConflict = Conflict("identity_email_conflict", "an account with this email already exists, sign in and link the provider instead")
+	ErrIdentityNotFound      = NotFound("identity_not_found", "linked provider not found")
+)
+
+// UserIdentity links an account at an external OpenID Connect provider to a
+// user. A provider subject can only be linked to one user.
+type UserIdentity struct {
+	gorm.Model
+	UserID   uint   `json:"user_id" gorm:"index"`
+	Provider string `json:"provider" gorm:"uniqueIndex:idx_user_identities_provider_subject"`
+	Subject  string `json:"subject" gorm:"uniqueIndex:idx_user_identities_provider_subject"`
+	Email    string `json:"email"`
+}
+
+// OIDCLoginState keeps what is needed to finish an authorization code flow
+// between the redirect to the provider and the callback. LinkUserID is set
+// when a signed-in user is linking a provider instead of signing in.
+type OIDCLoginState struct {
+	gorm.Model
+	StateHash    string `gorm:"uniqueIndex"`
+	Provider     string
+	Nonce        string
+	CodeVerifier string
+	LinkUserID   *uint
+	ExpiresAt    time.Time
+}
+
+// ExternalIdentity is the provider side of a sign-in, as verified from the
+// provider's ID token.
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+}
+
+// CreateOIDCLoginState stores a new pending sign-in and returns the opaque
+// state value to send to the provider.
+func CreateOIDCLoginState(db *gorm.DB, provider, codeVerifier string, linkUserID *uint) (string, *OIDCLoginState, error) {
+	state, err := generateOpaqueToken()
+	if err != nil {
+		return "", nil, err
+	}
+	nonce, err := generateOpaqueToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	login := OIDCLoginState{
+		StateHash:    hashToken(state),
+		Provider:     provider,
+		Nonce:        nonce,
+		CodeVerifier: codeVerifier,
+		LinkUserID:   linkUserID,
+		ExpiresAt:    time.Now().Add(OIDCLoginStateTTL),
+	}
+	if err := db.Create(&login).Error; err != nil {
+		return "", nil, err
+	}
+	return state, &login, nil
+}
+
+// ConsumeOIDCLoginState looks up and deletes a pending sign-in so each state
+// value can be used only once.
+func ConsumeOIDCLoginState(db *gorm.DB, provider, state string) (*OIDCLoginState, error) {
+	var login OIDCLoginState
+	err := db.Where("state_hash = ? AND provider = ? AND expires_at > ?", hashToken(state), provider, time.Now()).First(&login).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidLoginState
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := db.Unscoped().Delete(&OIDCLoginState{}, login.ID)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidLoginState
+	}
+	return &login, nil
+}
+
+// LinkIdentity attaches the external identity to the user.
+func LinkIdentity(db *gorm.DB, userID uint, identity ExternalIdentity) (*UserIdentity, error) {
+	var existing UserIdentity
+	err := db.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&existing).Error
+	if err == nil {
+		if existing.UserID != userID {
+			return nil, ErrIdentityLinked
+		}
+		return &existing, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	link := UserIdentity{
+		UserID:   userID,
+		Provider: identity.Provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+	}
+	if err := db.Create(&link).Error; err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// SignInWithIdentity returns the user linked to the external identity. When
+// there is none, an existing account with the same verified email is linked,
+// or a new passwordless account is created.
+func SignInWithIdentity(db *gorm.DB, identity ExternalIdentity) (*User, error) {
+	var link UserIdentity
+	err := db.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&link).Error
+	if err == nil {
+		var user User
+		if err := db.First(&user, link.UserID).Error; err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	email, err := NormalizeEmail(identity.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	var user *User
+	err = db.Transaction(func(tx *gorm.DB) error {
+		existing, err := GetUserByEmail(tx, email)
+		switch {
+		case err == nil:
+			// Only a provider that vouches for the address may take over an
+			// existing account, otherwise anyone could claim any email. The
+			// account must have verified the address too: an unverified one
+			// may have been registered by someone else, with a password they
+			// would keep after the owner signed in through the provider.
+			if !identity.EmailVerified || existing.EmailVerifiedAt == nil {
+				return ErrIdentityEmailConflict
+			}
+			user = existing
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			now := time.Now()
+			user = &User{
+				Username: identityUsername(identity, email),
+				Email:    email,
+				RoleID:   DefaultRoleID,
+			}
+			if identity.EmailVerified {
+				user.EmailVerifiedAt = &now
+			}
+			if err := tx.Create(user).Error; err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+
+		_, err = LinkIdentity(tx, user.ID, identity)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func identityUsername(identity ExternalIdentity, email string) string {
+	if identity.Username != "" {
+		return identity.Username
+	}
+	local, _, _ := strings.Cut(email, "@")
+	return local
+}
+
+// GetUserIdentities lists the providers linked to the user.
+func GetUserIdentities(db *gorm.DB, userID uint) ([]UserIdentity, error) {
+	var identities []UserIdentity
+	err := db.Where("user_id = ?", userID).Order("id").Find(&identities).Error
+	return identities, err
+}
+
+// UnlinkIdentity removes a linked provider from the user.
+func UnlinkIdentity(db *gorm.DB, userID, identityID uint) error {
+	result := db.Unscoped().Where("user_id = ?", userID).Delete(&UserIdentity{}, identityID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrIdentityNotFound
+	}
+	return nil
+}
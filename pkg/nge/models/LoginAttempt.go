@@ -0,0 +1,240 @@
+package models
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+const (
+	LoginReasonInvalidCredentials  = "invalid_credentials"
+	LoginReasonInvalidSecondFactor = "invalid_second_factor"
+	LoginReasonThrottled           = "throttled"
+)
+
+// failedLoginReasons are the reasons that count as failures for throttling.
+var failedLoginReasons = []string{LoginReasonInvalidCredentials, LoginReasonInvalidSecondFactor}
+
+// LoginAttempt is the audit record of a failed sign-in. UserID is nil when
+// the email does not belong to any account.
+type LoginAttempt struct {
+	gorm.Model
+	Email  string `json:"email" gorm:"index"`
+	UserID *uint  `json:"user_id" gorm:"index"`
+	IP     string `json:"ip" gorm:"index"`
+	Reason string `json:"reason"`
+}
+
+// LoginPolicy controls how failed sign-ins slow down further attempts.
+type LoginPolicy struct {
+	// BackoffThreshold is the number of consecutive failures for an account
+	// before each further attempt has to wait, starting at BackoffBase and
+	// doubling up to BackoffMax.
+	BackoffThreshold int
+	BackoffBase      time.Duration
+	BackoffMax       time.Duration
+	// MaxFailures consecutive failures lock the account for LockoutDuration
+	// or until an admin unlocks it.
+	MaxFailures     int
+	LockoutDuration time.Duration
+	// IPBackoffThreshold failures from one IP within IPWindow, across all
+	// accounts, start the same backoff for that IP.
+	IPBackoffThreshold int
+	IPWindow           time.Duration
+}
+
+// ErrLoginThrottled is returned, with how long to wait as RetryAfter, while
+// an account or IP has to wait before trying again. It deliberately looks the
+// same for locked accounts, backoff and unknown emails.
+var ErrLoginThrottled = TooManyRequests("login_throttled", "too many failed sign-in attempts, try again later")
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// compareDummyHash spends the same time as a real password check so unknown
+// emails cannot be told apart by response time.
+func compareDummyHash(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcryptCost)
+	})
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
+
+func (p LoginPolicy) backoff(failures int) time.Duration {
+	if failures < p.BackoffThreshold {
+		return 0
+	}
+
+	delay := p.BackoffBase
+	for i := p.BackoffThreshold; i < failures && delay < p.BackoffMax; i++ {
+		delay *= 2
+	}
+	if delay > p.BackoffMax {
+		delay = p.BackoffMax
+	}
+	return delay
+}
+
+func retryAfter(until, now time.Time) time.Duration {
+	if wait := until.Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+func (p LoginPolicy) ipRetryAfter(db *gorm.DB, ip string, now time.Time) (time.Duration, error) {
+	var stats struct {
+		Failures int
+		Last     *time.Time
+	}
+	err := db.Model(&LoginAttempt{}).
+		Select("count(*) AS failures, max(created_at) AS last").
+		Where("ip = ? AND reason IN ? AND created_at > ?", ip, failedLoginReasons, now.Add(-p.IPWindow)).
+		Scan(&stats).Error
+	if err != nil || stats.Last == nil {
+		return 0, err
+	}
+
+	// Shift the count so the account backoff curve starts at IPBackoffThreshold.
+	failures := stats.Failures - p.IPBackoffThreshold + p.BackoffThreshold
+	return retryAfter(stats.Last.Add(p.backoff(failures)), now), nil
+}
+
+// unknownEmailRetryAfter mirrors the account backoff for emails without an
+// account, so throttling does not reveal which emails are registered.
+func (p LoginPolicy) unknownEmailRetryAfter(db *gorm.DB, email string, now time.Time) (time.Duration, error) {
+	var stats struct {
+		Failures int
+		Last     *time.Time
+	}
+	err := db.Model(&LoginAttempt{}).
+		Select("count(*) AS failures, max(created_at) AS last").
+		Where("lower(email) = lower(?) AND user_id IS NULL AND reason = ? AND created_at > ?", email, LoginReasonInvalidCredentials, now.Add(-p.LockoutDuration)).
+		Scan(&stats).Error
+	if err != nil || stats.Last == nil {
+		return 0, err
+	}
+
+	if stats.Failures >= p.MaxFailures {
+		return retryAfter(stats.Last.Add(p.LockoutDuration), now), nil
+	}
+	return retryAfter(stats.Last.Add(p.backoff(stats.Failures)), now), nil
+}
+
+func (p LoginPolicy) accountRetryAfter(user *User, now time.Time) time.Duration {
+	if user.LockedUntil != nil {
+		if wait := retryAfter(*user.LockedUntil, now); wait > 0 {
+			return wait
+		}
+	}
+	if user.LastFailedLoginAt == nil {
+		return 0
+	}
+	return retryAfter(user.LastFailedLoginAt.Add(p.backoff(user.FailedLoginCount)), now)
+}
+
+func recordLoginAttempt(db *gorm.DB, email string, userID *uint, ip, reason string) {
+	db.Create(&LoginAttempt{Email: email, UserID: userID, IP: ip, Reason: reason})
+}
+
+// countFailedLogin adds a failure to the account and locks it once
+// MaxFailures is reached. The lockout is decided on the row's current count
+// within the update itself, so concurrent failures cannot each see a stale
+// count and skip it.
+func (p LoginPolicy) countFailedLogin(db *gorm.DB, userID uint, now time.Time) error {
+	updates := map[string]interface{}{
+		"failed_login_count":   gorm.Expr("CASE WHEN failed_login_count + 1 >= ? THEN 0 ELSE failed_login_count + 1 END", p.MaxFailures),
+		"locked_until":         gorm.Expr("CASE WHEN failed_login_count + 1 >= ? THEN ? ELSE locked_until END", p.MaxFailures, now.Add(p.LockoutDuration)),
+		"last_failed_login_at": now,
+	}
+	return db.Model(&User{}).Where("id = ?", userID).Updates(updates).Error
+}
+
+// Authenticate checks the email and password and returns the user, applying
+// the login policy for the account and the client IP. Unknown emails and
+// wrong passwords both return ErrInvalidCredentials. When the stored hash
+// uses a different cost than configured it is transparently replaced.
+func Authenticate(db *gorm.DB, policy LoginPolicy, email, password, ip string) (*User, error) {
+	now := time.Now()
+
+	wait, err := policy.ipRetryAfter(db, ip, now)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		recordLoginAttempt(db, email, nil, ip, LoginReasonThrottled)
+		return nil, ErrLoginThrottled.WithRetryAfter(wait)
+	}
+
+	user, err := GetUserByEmail(db, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		wait, err := policy.unknownEmailRetryAfter(db, email, now)
+		if err != nil {
+			return nil, err
+		}
+		if wait > 0 {
+			recordLoginAttempt(db, email, nil, ip, LoginReasonThrottled)
+			return nil, ErrLoginThrottled.WithRetryAfter(wait)
+		}
+
+		compareDummyHash(password)
+		recordLoginAttempt(db, email, nil, ip, LoginReasonInvalidCredentials)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if wait := policy.accountRetryAfter(user, now); wait > 0 {
+		recordLoginAttempt(db, email, &user.ID, ip, LoginReasonThrottled)
+		return nil, ErrLoginThrottled.WithRetryAfter(wait)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		if err := policy.countFailedLogin(db, user.ID, now); err != nil {
+			return nil, err
+		}
+
+		recordLoginAttempt(db, email, &user.ID, ip, LoginReasonInvalidCredentials)
+		return nil, ErrInvalidCredentials
+	}
+
+	// With two-factor authentication the failures are only forgotten once
+	// the second factor is checked too, or every correct password would
+	// reset the count of wrong codes.
+	if !user.TOTPEnabled() && (user.FailedLoginCount > 0 || user.LockedUntil != nil) {
+		if err := UnlockUser(db, user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if cost, err := bcrypt.Cost([]byte(user.Password)); err == nil && cost != bcryptCost {
+		// Best effort: a failed upgrade is simply retried on the next sign-in.
+		if hashed, err := HashPassword(password); err == nil && db.Model(user).Update("password", hashed).Error == nil {
+			user.Password = hashed
+		}
+	}
+
+	return user, nil
+}
+
+// UnlockUser lifts a lockout and resets the failed attempt counter.
+func UnlockUser(db *gorm.DB, userID uint) error {
+	result := db.Model(&User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"failed_login_count":   0,
+		"last_failed_login_at": nil,
+		"locked_until":         nil,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
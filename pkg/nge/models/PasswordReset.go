@@ -0,0 +1,93 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const PasswordResetTTL = time.Hour
+
+const MinPasswordLength = 8
+
+var (
+	ErrInvalidResetToken = Invalid("invalid_reset_token", "reset token is invalid or has expired")
+	ErrPasswordTooShort  = Invalid("password_too_short", "password must be at least 8 characters long").WithField("password", "must be at least 8 characters long")
+)
+
+// PasswordResetToken is a single-use token sent by email to reset a
+// forgotten password. Only the SHA-256 hash of the token is stored.
+type PasswordResetToken struct {
+	gorm.Model
+	UserID    uint       `json:"user_id" gorm:"index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// CreatePasswordResetToken issues a new reset token for the user. Any
+// previously issued tokens that have not been used yet stop working.
+func CreatePasswordResetToken(db *gorm.DB, userID uint) (string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND used_at IS NULL", userID).Delete(&PasswordResetToken{}).Error; err != nil {
+			return err
+		}
+
+		reset := PasswordResetToken{
+			UserID:    userID,
+			TokenHash: hashToken(token),
+			ExpiresAt: time.Now().Add(PasswordResetTTL),
+		}
+		return tx.Create(&reset).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ResetPassword consumes the reset token, sets the new password and signs
+// the user out everywhere.
+func ResetPassword(db *gorm.DB, token, newPassword string) error {
+	if len(newPassword) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+
+	hashedPassword, err := HashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var reset PasswordResetToken
+		err := tx.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), time.Now()).First(&reset).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+
+		result := tx.Model(&PasswordResetToken{}).
+			Where("id = ? AND used_at IS NULL", reset.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidResetToken
+		}
+
+		if err := tx.Model(&User{}).Where("id = ?", reset.UserID).Update("password", hashedPassword).Error; err != nil {
+			return err
+		}
+
+		return RevokeUserSessions(tx, reset.UserID)
+	})
+}
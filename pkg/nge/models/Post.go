@@ -6,23 +6,30 @@ import (
 
 type Post struct {
 	gorm.Model
-	Id   uint   `json:"id" gorm:"unique;primaryKey;autoIncrement"`
-	Text string `json:"text"`
+	Id       uint   `json:"id" gorm:"unique;primaryKey;autoIncrement"`
+	Text     string `json:"text"`
+	AuthorID uint   `json:"author_id" gorm:"index"`
 }
 
 func AddPost(db *gorm.DB, post Post) (Post, error) {
 	err := db.Create(&post).Error
+	if isUniqueViolation(err) {
+		return post, ErrDuplicatePost
+	}
 	return post, err
 }
 
 func GetPost(db *gorm.DB, id uint) (Post, error) {
 	var post Post
 	err := db.First(&post, id).Error
-	return post, err
+	return post, notFound(err, ErrPostNotFound)
 }
 
 func UpdatePost(db *gorm.DB, updatedPost Post) (Post, error) {
 	err := db.Save(&updatedPost).Error
+	if isUniqueViolation(err) {
+		return updatedPost, ErrDuplicatePost
+	}
 	return updatedPost, err
 }
 
@@ -31,6 +38,16 @@ func DeletePost(db *gorm.DB, id uint) error {
 	return err
 }
 
+// IsPostAuthor reports whether the user wrote the post. It returns
+// ErrPostNotFound when the post does not exist.
+func IsPostAuthor(db *gorm.DB, postID, userID uint) (bool, error) {
+	post, err := GetPost(db, postID)
+	if err != nil {
+		return false, err
+	}
+	return post.AuthorID == userID, nil
+}
+
 func GetAllPosts(db *gorm.DB) ([]Post, error) {
 	var posts []Post
 	err := db.Find(&posts).Error
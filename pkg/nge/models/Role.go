@@ -1,12 +1,140 @@
 package models
 
 import (
+	"fmt"
+
 	"gorm.io/gorm"
 )
 
+// Permissions checked by the API. Roles own any number of them.
+const (
+	PermUsersManage       = "users:manage"
+	PermUsersDelete       = "users:delete"
+	PermRolesManage       = "roles:manage"
+	PermPostsWrite        = "posts:write"
+	PermPostsModerate     = "posts:moderate"
+	PermEventsWrite       = "events:write"
+	PermEventsModerate    = "events:moderate"
+	PermCompaniesWrite    = "companies:write"
+	PermCompaniesModerate = "companies:moderate"
+)
+
+// AllPermissions lists every permission with a short description.
+var AllPermissions = []Permission{
+	{Name: PermUsersManage, Description: "Unlock users and assign roles"},
+	{Name: PermUsersDelete, Description: "Delete users"},
+	{Name: PermRolesManage, Description: "Create, edit and delete roles"},
+	{Name: PermPostsWrite, Description: "Create posts and edit own posts"},
+	{Name: PermPostsModerate, Description: "Edit and delete any post"},
+	{Name: PermEventsWrite, Description: "Create events and edit own events"},
+	{Name: PermEventsModerate, Description: "Edit and delete any event"},
+	{Name: PermCompaniesWrite, Description: "Create companies and edit own companies"},
+	{Name: PermCompaniesModerate, Description: "Edit and delete any company"},
+}
+
+// Default roles. Their IDs are fixed so existing users keep their meaning:
+// role 2 has always been the administrator.
+const (
+	RoleUser      = 1
+	RoleAdmin     = 2
+	RoleModerator = 3
+)
+
+// DefaultRoleID is the role given to every new account.
+const DefaultRoleID = RoleUser
+
+var defaultRoles = []struct {
+	ID          int
+	Title       string
+	Permissions []string
+}{
+	{RoleUser, "user", []string{PermPostsWrite, PermEventsWrite, PermCompaniesWrite}},
+	{RoleAdmin, "admin", nil}, // every permission
+	{RoleModerator, "moderator", []string{
+		PermPostsWrite, PermEventsWrite, PermCompaniesWrite,
+		PermPostsModerate, PermEventsModerate, PermCompaniesModerate,
+	}},
+}
+
+var (
+	ErrUnknownPermission = Invalid("unknown_permission", "unknown permission")
+	ErrRoleInUse         = Conflict("role_in_use", "role is still assigned to users")
+	ErrDefaultRole       = Conflict("default_role", "default roles cannot be deleted")
+)
+
+type Permission struct {
+	ID          uint   `json:"id"`
+	Name        string `json:"name" gorm:"uniqueIndex"`
+	Description string `json:"description"`
+}
+
 type Role struct {
-	ID int
-	Title  string
+	ID          int
+	Title       string
+	Permissions []Permission `gorm:"many2many:role_permissions;"`
+}
+
+func IsPermission(name string) bool {
+	for _, permission := range AllPermissions {
+		if permission.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func findPermissions(db *gorm.DB, names []string) ([]Permission, error) {
+	for _, name := range names {
+		if !IsPermission(name) {
+			return nil, fmt.Errorf("%w: %q", ErrUnknownPermission, name)
+		}
+	}
+
+	permissions := []Permission{}
+	if len(names) == 0 {
+		return permissions, nil
+	}
+	err := db.Where("name IN ?", names).Find(&permissions).Error
+	return permissions, err
+}
+
+// SyncRoles makes sure every permission and default role exists. Default
+// roles get any default permission they are missing; permissions an admin
+// added or removed on other roles are left alone.
+func SyncRoles(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, permission := range AllPermissions {
+			p := permission
+			if err := tx.Where(Permission{Name: p.Name}).Assign(Permission{Description: p.Description}).FirstOrCreate(&p).Error; err != nil {
+				return err
+			}
+		}
+
+		for _, def := range defaultRoles {
+			role := Role{ID: def.ID}
+			if err := tx.Where(Role{ID: def.ID}).Attrs(Role{Title: def.Title}).FirstOrCreate(&role).Error; err != nil {
+				return err
+			}
+
+			var permissions []Permission
+			var err error
+			if def.Permissions == nil {
+				err = tx.Find(&permissions).Error
+			} else {
+				permissions, err = findPermissions(tx, def.Permissions)
+			}
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&role).Association("Permissions").Append(permissions); err != nil {
+				return err
+			}
+		}
+
+		// Default roles are inserted with explicit IDs, which does not move
+		// the sequence used for roles created later.
+		return tx.Exec("SELECT setval(pg_get_serial_sequence('roles', 'id'), (SELECT MAX(id) FROM roles))").Error
+	})
 }
 
 func AddRole(db *gorm.DB, role Role) (Role, error) {
@@ -14,3 +142,105 @@ func AddRole(db *gorm.DB, role Role) (Role, error) {
 	return role, err
 }
 
+// CreateRole creates a role with the named permissions.
+func CreateRole(db *gorm.DB, title string, permissionNames []string) (Role, error) {
+	permissions, err := findPermissions(db, permissionNames)
+	if err != nil {
+		return Role{}, err
+	}
+	return AddRole(db, Role{Title: title, Permissions: permissions})
+}
+
+func GetRoles(db *gorm.DB) ([]Role, error) {
+	var roles []Role
+	err := db.Preload("Permissions").Order("id").Find(&roles).Error
+	return roles, err
+}
+
+func GetRoleByID(db *gorm.DB, roleID int) (Role, error) {
+	var role Role
+	err := db.Preload("Permissions").First(&role, roleID).Error
+	return role, notFound(err, ErrRoleNotFound)
+}
+
+// UpdateRole renames the role and replaces its permissions.
+func UpdateRole(db *gorm.DB, roleID int, title string, permissionNames []string) (Role, error) {
+	permissions, err := findPermissions(db, permissionNames)
+	if err != nil {
+		return Role{}, err
+	}
+
+	role, err := GetRoleByID(db, roleID)
+	if err != nil {
+		return Role{}, err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if title != "" && title != role.Title {
+			if err := tx.Model(&role).Update("title", title).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&role).Association("Permissions").Replace(permissions)
+	})
+	if err != nil {
+		return Role{}, err
+	}
+	return GetRoleByID(db, roleID)
+}
+
+// DeleteRole deletes a role that is neither a default role nor assigned to
+// any user.
+func DeleteRole(db *gorm.DB, roleID int) error {
+	for _, def := range defaultRoles {
+		if def.ID == roleID {
+			return ErrDefaultRole
+		}
+	}
+
+	var users int64
+	if err := db.Model(&User{}).Where("role_id = ?", roleID).Count(&users).Error; err != nil {
+		return err
+	}
+	if users > 0 {
+		return ErrRoleInUse
+	}
+
+	role, err := GetRoleByID(db, roleID)
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&role).Association("Permissions").Clear(); err != nil {
+			return err
+		}
+		return tx.Delete(&role).Error
+	})
+}
+
+// AssignRole changes the role of a user.
+func AssignRole(db *gorm.DB, userID uint, roleID int) error {
+	if _, err := GetRoleByID(db, roleID); err != nil {
+		return err
+	}
+
+	result := db.Model(&User{}).Where("id = ?", userID).Update("role_id", roleID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// UserHasPermission reports whether the user's role grants the permission.
+func UserHasPermission(db *gorm.DB, userID uint, permission string) (bool, error) {
+	var count int64
+	err := db.Table("users").
+		Joins("JOIN role_permissions ON role_permissions.role_id = users.role_id").
+		Joins("JOIN permissions ON permissions.id = role_permissions.permission_id").
+		Where("users.id = ? AND users.deleted_at IS NULL AND permissions.name = ?", userID, permission).
+		Count(&count).Error
+	return count > 0, err
+}
@@ -0,0 +1,229 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidRefreshToken = Unauthorized("invalid_refresh_token", "invalid refresh token")
+	ErrRefreshTokenReused  = Unauthorized("refresh_token_reused", "refresh token reuse detected, session revoked")
+	ErrSessionRevoked      = Unauthorized("session_revoked", "session has been revoked or has expired")
+)
+
+// Session represents a single sign-in of a user. Access tokens carry the
+// session ID so that revoking the session invalidates them immediately.
+type Session struct {
+	gorm.Model
+	UserID    uint       `json:"user_id" gorm:"index"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at"`
+}
+
+// RefreshToken is one link in the rotation chain of a session. Only the
+// SHA-256 hash of the token is stored.
+type RefreshToken struct {
+	gorm.Model
+	SessionID uint       `json:"session_id" gorm:"index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// TokenPair is what a successful sign-in or refresh hands back to the client.
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+func (s Session) Active() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+func generateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func issueRefreshToken(db *gorm.DB, session Session) (string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	refresh := RefreshToken{
+		SessionID: session.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: session.ExpiresAt,
+	}
+	if err := db.Create(&refresh).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func newTokenPair(db *gorm.DB, session Session) (*TokenPair, error) {
+	refreshToken, err := issueRefreshToken(db, session)
+	if err != nil {
+		return nil, err
+	}
+
+	accessToken, err := GenerateToken(session.UserID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// CreateSession starts a new session for the user and returns its first token
+// pair. It fails with ErrAccountDisabled for disabled users.
+func CreateSession(db *gorm.DB, userID uint) (*TokenPair, error) {
+	var pair *TokenPair
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := checkUserEnabled(tx, userID); err != nil {
+			return err
+		}
+
+		session := Session{
+			UserID:    userID,
+			ExpiresAt: time.Now().Add(RefreshTokenTTL),
+		}
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+
+		var err error
+		pair, err = newTokenPair(tx, session)
+		return err
+	})
+	return pair, err
+}
+
+// RefreshSession exchanges a refresh token for a new token pair. Every refresh
+// token can be used exactly once; presenting one that was already rotated is
+// treated as theft and revokes the whole session.
+func RefreshSession(db *gorm.DB, refreshToken string) (*TokenPair, error) {
+	var stored RefreshToken
+	if err := db.Where("token_hash = ?", hashToken(refreshToken)).First(&stored).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	if stored.UsedAt != nil {
+		if err := RevokeSession(db, stored.SessionID); err != nil {
+			return nil, err
+		}
+		return nil, ErrRefreshTokenReused
+	}
+
+	var session Session
+	if err := db.First(&session, stored.SessionID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionRevoked
+		}
+		return nil, err
+	}
+	if !session.Active() || time.Now().After(stored.ExpiresAt) {
+		return nil, ErrSessionRevoked
+	}
+	if err := checkUserEnabled(db, session.UserID); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrSessionRevoked
+		}
+		return nil, err
+	}
+
+	var pair *TokenPair
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// The used_at guard makes concurrent refreshes with the same token
+		// race safely: only one of them can mark it as used.
+		result := tx.Model(&RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", stored.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrRefreshTokenReused
+		}
+
+		var err error
+		pair, err = newTokenPair(tx, session)
+		return err
+	})
+	if errors.Is(err, ErrRefreshTokenReused) {
+		if err := RevokeSession(db, session.ID); err != nil {
+			return nil, err
+		}
+	}
+	return pair, err
+}
+
+// RevokeSession marks the session as revoked. Revoking an already revoked
+// session is a no-op.
+func RevokeSession(db *gorm.DB, sessionID uint) error {
+	return db.Model(&Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeUserSessions revokes every active session of the user.
+func RevokeUserSessions(db *gorm.DB, userID uint) error {
+	return db.Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeAllSessions revokes every active session of every user and returns
+// how many were revoked.
+func RevokeAllSessions(db *gorm.DB) (int64, error) {
+	result := db.Model(&Session{}).
+		Where("revoked_at IS NULL").
+		Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// IsSessionActive reports whether the session exists, belongs to a user that
+// is neither deleted nor disabled and has been neither revoked nor expired.
+func IsSessionActive(db *gorm.DB, sessionID, userID uint) (bool, error) {
+	var session Session
+	err := db.Joins("JOIN users ON users.id = sessions.user_id AND users.deleted_at IS NULL AND users.disabled_at IS NULL").
+		Where("sessions.id = ? AND sessions.user_id = ?", sessionID, userID).
+		First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return session.Active(), nil
+}
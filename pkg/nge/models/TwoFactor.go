@@ -0,0 +1,323 @@
+package models
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	TOTPIssuer         = "NGE"
+	MFAChallengeTTL    = 5 * time.Minute
+	MaxMFAAttempts     = 5
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+var (
+	ErrTOTPAlreadyEnabled  = Conflict("totp_already_enabled", "two-factor authentication is already enabled")
+	ErrTOTPNotEnrolled     = Conflict("totp_not_enrolled", "two-factor enrollment has not been started")
+	ErrTOTPNotEnabled      = Conflict("totp_not_enabled", "two-factor authentication is not enabled")
+	ErrInvalidMFACode      = Unauthorized("invalid_mfa_code", "invalid authentication code")
+	ErrInvalidMFAChallenge = Unauthorized("invalid_mfa_challenge", "sign-in challenge is invalid or has expired, sign in again")
+)
+
+// RecoveryCode is a one-time code that replaces a TOTP code when the
+// authenticator is lost. Only the SHA-256 hash is stored.
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint       `json:"user_id" gorm:"index"`
+	CodeHash string     `json:"-" gorm:"uniqueIndex"`
+	UsedAt   *time.Time `json:"used_at"`
+}
+
+// MFAChallenge is handed out by the first sign-in step to users with
+// two-factor authentication and exchanged for a session once the second
+// factor is verified.
+type MFAChallenge struct {
+	gorm.Model
+	UserID    uint       `json:"user_id" gorm:"index"`
+	TokenHash string     `json:"-" gorm:"uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	Attempts  int        `json:"attempts"`
+	UsedAt    *time.Time `json:"used_at"`
+}
+
+// MFAChallengeResponse is returned by sign-in instead of tokens when a second
+// factor is required.
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// TOTPEnrollment is what an authenticator app needs to be set up.
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+func (u User) TOTPEnabled() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+// BeginTOTPEnrollment generates a new secret for the user. Two-factor
+// authentication only becomes active once ConfirmTOTPEnrollment succeeds.
+func BeginTOTPEnrollment(db *gorm.DB, user User) (*TOTPEnrollment, error) {
+	if user.TOTPEnabled() {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	raw := make([]byte, 20)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	secret := totpEncoding.EncodeToString(raw)
+
+	if err := db.Model(&User{}).Where("id = ?", user.ID).Update("totp_secret", secret).Error; err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollment{Secret: secret, URI: totpURI(TOTPIssuer, user.Email, secret)}, nil
+}
+
+// ConfirmTOTPEnrollment enables two-factor authentication once the user
+// proves their authenticator works, and returns fresh recovery codes.
+func ConfirmTOTPEnrollment(db *gorm.DB, user User, code string) ([]string, error) {
+	if user.TOTPEnabled() {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnrolled
+	}
+
+	step, ok := matchTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"totp_enabled_at": time.Now(),
+			"totp_last_step":  step,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	return codes, err
+}
+
+// DisableTOTP turns two-factor authentication off after checking a current
+// TOTP or recovery code.
+func DisableTOTP(db *gorm.DB, user User, code string) error {
+	if !user.TOTPEnabled() {
+		return ErrTOTPNotEnabled
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := VerifySecondFactor(tx, user, code); err != nil {
+			return err
+		}
+
+		err := tx.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+			"totp_last_step":  0,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error
+	})
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes of the user after
+// checking a current TOTP or recovery code.
+func RegenerateRecoveryCodes(db *gorm.DB, user User, code string) ([]string, error) {
+	if !user.TOTPEnabled() {
+		return nil, ErrTOTPNotEnabled
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := VerifySecondFactor(tx, user, code); err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	return codes, err
+}
+
+func replaceRecoveryCodes(db *gorm.DB, userID uint) ([]string, error) {
+	if err := db.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		if err := db.Create(&RecoveryCode{UserID: userID, CodeHash: hashToken(code)}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func generateRecoveryCode() (string, error) {
+	raw := make([]byte, recoveryCodeLength)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(totpEncoding.EncodeToString(raw))[:recoveryCodeLength]
+	return code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	if len(code) != recoveryCodeLength {
+		return ""
+	}
+	return code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+}
+
+// VerifySecondFactor accepts either a TOTP code or an unused recovery code.
+// A TOTP code is accepted only once so an observed code cannot be replayed.
+func VerifySecondFactor(db *gorm.DB, user User, code string) error {
+	if step, ok := matchTOTP(user.TOTPSecret, code, time.Now()); ok {
+		result := db.Model(&User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if normalized == "" {
+		return ErrInvalidMFACode
+	}
+
+	result := db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalized)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// CreateMFAChallenge starts the second sign-in step for the user.
+func CreateMFAChallenge(db *gorm.DB, userID uint) (*MFAChallengeResponse, error) {
+	if err := checkUserEnabled(db, userID); err != nil {
+		return nil, err
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	challenge := MFAChallenge{
+		UserID:    userID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(MFAChallengeTTL),
+	}
+	if err := db.Create(&challenge).Error; err != nil {
+		return nil, err
+	}
+
+	return &MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int(MFAChallengeTTL.Seconds()),
+	}, nil
+}
+
+// CompleteMFAChallenge checks the second factor for a pending challenge and
+// returns the user it belongs to. A challenge can be completed once and
+// allows at most MaxMFAAttempts wrong codes. Wrong codes also count as
+// failed sign-ins of the account under policy, so starting new challenges
+// does not allow more guesses than the account lockout.
+func CompleteMFAChallenge(db *gorm.DB, policy LoginPolicy, token, code, ip string) (*User, error) {
+	var challenge MFAChallenge
+	err := db.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), time.Now()).First(&challenge).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidMFAChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Count the attempt before checking the code, in a single statement, so
+	// that parallel guesses cannot all slip under the limit.
+	result := db.Model(&MFAChallenge{}).
+		Where("id = ? AND attempts < ?", challenge.ID, MaxMFAAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	var user User
+	if err := db.First(&user, challenge.UserID).Error; err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if wait := policy.accountRetryAfter(&user, now); wait > 0 {
+		recordLoginAttempt(db, user.Email, &user.ID, ip, LoginReasonThrottled)
+		return nil, ErrLoginThrottled.WithRetryAfter(wait)
+	}
+
+	if err := VerifySecondFactor(db, user, code); err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			if err := policy.countFailedLogin(db, user.ID, now); err != nil {
+				return nil, err
+			}
+			recordLoginAttempt(db, user.Email, &user.ID, ip, LoginReasonInvalidSecondFactor)
+		}
+		return nil, err
+	}
+
+	result = db.Model(&MFAChallenge{}).
+		Where("id = ? AND used_at IS NULL", challenge.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	if user.FailedLoginCount > 0 || user.LockedUntil != nil {
+		if err := UnlockUser(db, user.ID); err != nil {
+			return nil, err
+		}
+	}
+	return &user, nil
+}
@@ -1,7 +1,10 @@
 package models
 
 import (
+	"database/sql"
 	"errors"
+	"net/mail"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt"
@@ -12,125 +15,222 @@ import (
 )
 
 type User struct {
-    gorm.Model
-    Username       string
-    Email          string
-    Password       string
-    ShownPassword  string
-    RoleID         int
-	Role 		  Role 	`gorm:"foreignKey:RoleID"`
+	gorm.Model
+	Username string
+	Email    string
+	Password string `json:"-"`
+	RoleID   int
+	Role     Role `gorm:"foreignKey:RoleID"`
+
+	EmailVerifiedAt    *time.Time
+	VerificationSentAt *time.Time
+
+	TOTPSecret    string `json:"-"`
+	TOTPEnabledAt *time.Time
+	TOTPLastStep  int64 `json:"-" gorm:"not null;default:0"`
+
+	FailedLoginCount  int        `json:"-" gorm:"not null;default:0"`
+	LastFailedLoginAt *time.Time `json:"-"`
+	LockedUntil       *time.Time
+
+	// DisabledAt is set by an operator. Disabled users cannot sign in.
+	DisabledAt *time.Time
 }
 
+// UserResponse is the public representation of a user. Handlers must use it
+// instead of User so credentials never end up in a response.
+type UserResponse struct {
+	ID               uint      `json:"id"`
+	Username         string    `json:"username"`
+	Email            string    `json:"email"`
+	RoleID           int       `json:"role_id"`
+	Role             *Role     `json:"role,omitempty"`
+	EmailVerified    bool      `json:"email_verified"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	Disabled         bool      `json:"disabled"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+func (u User) Response() UserResponse {
+	response := UserResponse{
+		ID:               u.ID,
+		Username:         u.Username,
+		Email:            u.Email,
+		RoleID:           u.RoleID,
+		EmailVerified:    u.EmailVerified(),
+		TwoFactorEnabled: u.TOTPEnabled(),
+		Disabled:         u.DisabledAt != nil,
+		CreatedAt:        u.CreatedAt,
+		UpdatedAt:        u.UpdatedAt,
+	}
+	if u.Role.ID != 0 {
+		role := u.Role
+		response.Role = &role
+	}
+	return response
+}
+
+var (
+	ErrInvalidEmail       = Invalid("invalid_email", "invalid email address").WithField("email", "is not a valid email address")
+	ErrEmailTaken         = Conflict("email_taken", "email address is already registered")
+	ErrInvalidCredentials = Unauthorized("invalid_credentials", "invalid credentials")
+	ErrAccountDisabled    = Forbidden("account_disabled", "account has been disabled")
+)
+
+// bcryptCost is the work factor for new password hashes. Existing hashes
+// with a different cost are upgraded on the next successful sign-in.
+var bcryptCost = 14
+
+// SetBcryptCost changes the work factor used by HashPassword.
+func SetBcryptCost(cost int) error {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	bcryptCost = cost
+	return nil
+}
+
+// NormalizeEmail validates the address and returns it lower-cased so that
+// lookups and the uniqueness check are case insensitive.
+func NormalizeEmail(email string) (string, error) {
+	addr, err := mail.ParseAddress(strings.TrimSpace(email))
+	if err != nil || addr.Name != "" {
+		return "", ErrInvalidEmail
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+func (u User) EmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
 
 type Claims struct {
-	UserID uint
+	UserID    uint
+	SessionID uint
 	jwt.StandardClaims
 }
 
-var jwtKey = []byte("env.jwtKey")
-
-func GenerateToken(userID uint)(string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+func GenerateToken(userID, sessionID uint) (string, error) {
+	expirationTime := time.Now().Add(AccessTokenTTL)
 	claims := &Claims{
-		UserID: userID,
+		UserID:    userID,
+		SessionID: sessionID,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expirationTime.Unix(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
-	return tokenString, err
+	return signClaims(claims)
 }
 
 func ValidateToken(tokenString string) (*Claims, error) {
-    claims := &Claims{}
+	claims := &Claims{}
 
-    token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-        return jwtKey, nil
-    })
+	token, err := jwt.ParseWithClaims(tokenString, claims, verificationKey)
 
-    if err != nil {
-        return nil, err
-    }
+	if err != nil {
+		return nil, err
+	}
 
-    if !token.Valid {
-        return nil, fmt.Errorf("invalid token")
-    }
+	// Tokens with an audience are purpose specific (email verification and
+	// the like) and must never be accepted as access tokens.
+	if !token.Valid || claims.Audience != "" {
+		return nil, fmt.Errorf("invalid token")
+	}
 
-    return claims, nil
+	return claims, nil
 }
-func HashPassword(password string)(string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+func HashPassword(password string) (string, error) {
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
 	return string(bytes), err
 }
 
 func GetUserByEmail(db *gorm.DB, email string) (*User, error) {
 	var user User
-	result := db.Where("email = ?", email).First(&user)
+	result := db.Where("lower(email) = lower(?)", strings.TrimSpace(email)).First(&user)
 	if result.Error != nil {
-		return nil, result.Error
+		return nil, notFound(result.Error, ErrUserNotFound)
 	}
 	return &user, nil
 }
 
-func Signup(db *gorm.DB, username, email, password string, roleID int) (*User, error) {
-    shownPassword := password
-    hashedPassword, err := HashPassword(password)
-    if err != nil {
-        return nil, err
-    }
-
-    user := User{
-        Username: username,
-        Email: email,
-        Password: hashedPassword,
-        ShownPassword: shownPassword,
-        RoleID: roleID,
-    }
-
-    result := db.Create(&user)
-    if result.Error != nil {
-        return nil, result.Error
-    }
-
-    err = db.Preload("Role").First(&user, user.ID).Error
-    if err != nil {
-        return nil, err
-    }
-
-    return &user, nil
-}
+// Signup creates an account with the default role. Other roles can only be
+// given by an administrator.
+func Signup(db *gorm.DB, username, email, password string) (*User, error) {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if len(password) < MinPasswordLength {
+		return nil, ErrPasswordTooShort
+	}
+
+	if _, err := GetUserByEmail(db, email); err == nil {
+		return nil, ErrEmailTaken
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	user := User{
+		Username: username,
+		Email:    email,
+		Password: hashedPassword,
+		RoleID:   DefaultRoleID,
+	}
+
+	// The check above is only for the common case; a concurrent sign-up with
+	// the same email is caught by idx_users_email_lower.
+	if err := db.Create(&user).Error; err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrEmailTaken
+		}
+		return nil, err
+	}
+
+	err = db.Preload("Role").First(&user, user.ID).Error
+	if err != nil {
+		return nil, err
+	}
 
+	return &user, nil
+}
 
 func UpdateUser(db *gorm.DB, user User) (User, error) {
 	result := db.Save(user).Error
 	return user, result
 }
 
+// DeleteUser deletes the user and revokes their sessions and API keys, so
+// access ends right away.
 func DeleteUser(db *gorm.DB, id uint) error {
-	result := db.Delete(&User{}, id)
-	if result.Error != nil {
-		return result.Error
-	}
-	return nil
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&User{}, id).Error; err != nil {
+			return err
+		}
+		if err := RevokeUserSessions(tx, id); err != nil {
+			return err
+		}
+		_, err := RevokeAPIKeys(tx, id)
+		return err
+	})
 }
 
 func GetUserRole(db *gorm.DB, userID uint) (uint, error) {
-    var roleID uint
-	fmt.Println("dada")
-	fmt.Println(userID)
-	fmt.Println("dada")
-
-    query := "SELECT role_id FROM users WHERE id = ?"
-    if err := db.Raw(query, userID).Row().Scan(&roleID); err != nil {
-        if err == gorm.ErrRecordNotFound {
-            return 0, errors.New("user not found")
-        }
-        return 0, err
-    }
-
-	fmt.Println("role", roleID)
-
-    return roleID, nil
+	var roleID uint
+
+	query := "SELECT role_id FROM users WHERE id = ?"
+	if err := db.Raw(query, userID).Row().Scan(&roleID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrUserNotFound
+		}
+		return 0, err
+	}
+
+	return roleID, nil
 }
@@ -0,0 +1,103 @@
+package models
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GetUsers returns every user with their role, oldest first.
+func GetUsers(db *gorm.DB) ([]User, error) {
+	var users []User
+	err := db.Preload("Role").Order("id").Find(&users).Error
+	return users, err
+}
+
+// FindUser looks a user up by ID or, when ref is not a number, by email.
+func FindUser(db *gorm.DB, ref string) (*User, error) {
+	if id, err := strconv.ParseUint(ref, 10, 64); err == nil {
+		var user User
+		if err := db.First(&user, id).Error; err != nil {
+			return nil, notFound(err, ErrUserNotFound)
+		}
+		return &user, nil
+	}
+	return GetUserByEmail(db, ref)
+}
+
+// CreateAdmin creates an administrator whose email address counts as
+// verified, for bootstrapping a new installation.
+func CreateAdmin(db *gorm.DB, username, email, password string) (*User, error) {
+	email, err := NormalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+	if len(password) < MinPasswordLength {
+		return nil, ErrPasswordTooShort
+	}
+
+	if _, err := GetUserByEmail(db, email); err == nil {
+		return nil, ErrEmailTaken
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	hashedPassword, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	user := User{
+		Username:        username,
+		Email:           email,
+		Password:        hashedPassword,
+		RoleID:          RoleAdmin,
+		EmailVerifiedAt: &now,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrEmailTaken
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// DisableUser stops the user from signing in and revokes their sessions and
+// API keys, so access ends right away.
+func DisableUser(db *gorm.DB, userID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).
+			Where("id = ? AND disabled_at IS NULL", userID).
+			Update("disabled_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if err := RevokeUserSessions(tx, userID); err != nil {
+			return err
+		}
+		_, err := RevokeAPIKeys(tx, userID)
+		return err
+	})
+}
+
+// EnableUser lets a disabled user sign in again.
+func EnableUser(db *gorm.DB, userID uint) error {
+	return db.Model(&User{}).Where("id = ?", userID).Update("disabled_at", nil).Error
+}
+
+// checkUserEnabled returns ErrAccountDisabled when the user has been
+// disabled and ErrUserNotFound when they do not exist or were deleted.
+func checkUserEnabled(db *gorm.DB, userID uint) error {
+	var user User
+	if err := db.Select("id", "disabled_at").First(&user, userID).Error; err != nil {
+		return notFound(err, ErrUserNotFound)
+	}
+	if user.DisabledAt != nil {
+		return ErrAccountDisabled
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// Kind classifies domain errors by what the caller did wrong. The API
+// answers each kind with its own status code.
+type Kind int
+
+const (
+	// KindValidation means the input is malformed or breaks a rule.
+	KindValidation Kind = iota + 1
+	// KindUnauthorized means credentials or tokens are missing or invalid.
+	KindUnauthorized
+	// KindForbidden means the caller is known but may not do this.
+	KindForbidden
+	// KindNotFound means a record the request refers to does not exist.
+	KindNotFound
+	// KindConflict means the request clashes with the current state, such
+	// as a duplicate.
+	KindConflict
+	// KindTooManyRequests means the caller has to slow down.
+	KindTooManyRequests
+	// KindUpstream means a service the request depends on, such as an
+	// identity provider, failed.
+	KindUpstream
+)
+
+// Error is a domain error the client can act on. Code identifies it for
+// programs, such as "email_taken", and stays the same when the message is
+// reworded. Fields holds a message per invalid input field.
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	Fields  map[string]string
+	// RetryAfter is how long a caller that has to slow down should wait.
+	RetryAfter time.Duration
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches errors with the same code, so that a copy with details, such
+// as the one returned by WithField, still matches its sentinel.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// WithField returns a copy of e reporting message for field.
+func (e *Error) WithField(field, message string) *Error {
+	copied := *e
+	copied.Fields = map[string]string{field: message}
+	for k, v := range e.Fields {
+		if k != field {
+			copied.Fields[k] = v
+		}
+	}
+	return &copied
+}
+
+// WithRetryAfter returns a copy of e asking the caller to wait d.
+func (e *Error) WithRetryAfter(d time.Duration) *Error {
+	copied := *e
+	copied.RetryAfter = d
+	return &copied
+}
+
+// WithCause returns a copy of e wrapping err, for errors.Is and the logs.
+func (e *Error) WithCause(err error) *Error {
+	copied := *e
+	copied.Err = err
+	return &copied
+}
+
+func newError(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+// Invalid returns a validation error.
+func Invalid(code, message string) *Error {
+	return newError(KindValidation, code, message)
+}
+
+// InvalidField returns a validation error about a single field, such as
+// InvalidField("name", "is required").
+func InvalidField(field, message string) *Error {
+	return Invalid("invalid_field", field+" "+message).WithField(field, message)
+}
+
+// Unauthorized returns an error for missing or invalid credentials.
+func Unauthorized(code, message string) *Error {
+	return newError(KindUnauthorized, code, message)
+}
+
+// Forbidden returns an error for an action the caller may not take.
+func Forbidden(code, message string) *Error {
+	return newError(KindForbidden, code, message)
+}
+
+// NotFound returns an error for a missing record. It matches
+// gorm.ErrRecordNotFound with errors.Is.
+func NotFound(code, message string) *Error {
+	return &Error{Kind: KindNotFound, Code: code, Message: message, Err: gorm.ErrRecordNotFound}
+}
+
+// Conflict returns an error for a request that clashes with existing data.
+func Conflict(code, message string) *Error {
+	return newError(KindConflict, code, message)
+}
+
+// TooManyRequests returns an error for a caller that has to slow down.
+func TooManyRequests(code, message string) *Error {
+	return newError(KindTooManyRequests, code, message)
+}
+
+// Upstream returns an error for a failed call to another service.
+func Upstream(code, message string) *Error {
+	return newError(KindUpstream, code, message)
+}
+
+var (
+	ErrUserNotFound    = NotFound("user_not_found", "user not found")
+	ErrPostNotFound    = NotFound("post_not_found", "post not found")
+	ErrEventNotFound   = NotFound("event_not_found", "event not found")
+	ErrCompanyNotFound = NotFound("company_not_found", "company not found")
+	ErrRoleNotFound    = NotFound("role_not_found", "role not found")
+	ErrDuplicatePost   = Conflict("duplicate_post", "a post with this text already exists")
+)
+
+// notFound replaces gorm.ErrRecordNotFound with notFoundErr, which names
+// the missing record, and returns other errors unchanged.
+func notFound(err error, notFoundErr *Error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return notFoundErr
+	}
+	return err
+}
+
+// isUniqueViolation reports whether err comes from a unique constraint.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
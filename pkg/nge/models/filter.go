@@ -39,19 +39,19 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 
 func (f Filters) Validate() error {
 	if f.Page <= 0 {
-		return fmt.Errorf("page must be greater than 0")
+		return InvalidField("page", "must be greater than 0")
 	}
 	if f.Page > 10_000_0000 {
-		return fmt.Errorf("page must be a maximum of 10 million")
+		return InvalidField("page", "must be a maximum of 10 million")
 	}
 	if f.PageSize <= 0 {
-		return fmt.Errorf("page_size must be greater than 0")
+		return InvalidField("page_size", "must be greater than 0")
 	}
 	if f.PageSize > 100 {
-		return fmt.Errorf("page_size must be a maximum of 100")
+		return InvalidField("page_size", "must be a maximum of 100")
 	}
 	if !isValidSort(f.Sort, f.SortSafeList) {
-		return fmt.Errorf("invalid sort value")
+		return InvalidField("sort", "is not a valid sort value")
 	}
 	return nil
 }
@@ -93,4 +93,4 @@ func FetchPosts(db *gorm.DB, limit, offset int, sortColumn, sortDirection string
 	}
 
 	return posts, nil
-}
\ No newline at end of file
+}
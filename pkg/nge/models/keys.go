@@ -0,0 +1,226 @@
+package models
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt"
+
+	initializers "github.com/Skapar/NGE/pkg/nge/database/initializers"
+)
+
+type signingKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// Private is the key used to sign tokens. It is nil for keys that are
+	// only kept around to verify tokens issued before a rotation.
+	Private interface{}
+	Public  interface{}
+}
+
+type keySet struct {
+	current      *signingKey
+	verification map[string]*signingKey
+}
+
+var keys *keySet
+
+// JWK is a single entry of a JSON Web Key Set as described in RFC 7517.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// InitSigningKeys loads the token signing key and all keys that are still
+// accepted for verification. It must be called before any token is issued.
+func InitSigningKeys(config *initializers.Config) error {
+	set := &keySet{verification: map[string]*signingKey{}}
+
+	kid := config.JWTKeyID
+	if kid == "" {
+		kid = "default"
+	}
+
+	switch config.JWTAlgorithm {
+	case "", "HS256":
+		if config.JWTSecret == "" {
+			return errors.New("JWT_SECRET is required when JWT_ALGORITHM is HS256")
+		}
+		secret := []byte(config.JWTSecret)
+		set.current = &signingKey{ID: kid, Method: jwt.SigningMethodHS256, Private: secret, Public: secret}
+
+		previous, err := parseKeyList(config.JWTPreviousSecrets)
+		if err != nil {
+			return fmt.Errorf("JWT_PREVIOUS_SECRETS: %w", err)
+		}
+		for id, secret := range previous {
+			set.verification[id] = &signingKey{ID: id, Method: jwt.SigningMethodHS256, Public: []byte(secret)}
+		}
+	case "RS256", "EdDSA":
+		if config.JWTPrivateKeyFile == "" {
+			return fmt.Errorf("JWT_PRIVATE_KEY_FILE is required when JWT_ALGORITHM is %s", config.JWTAlgorithm)
+		}
+		current, err := loadPrivateKey(kid, config.JWTAlgorithm, config.JWTPrivateKeyFile)
+		if err != nil {
+			return err
+		}
+		set.current = current
+
+		previous, err := parseKeyList(config.JWTVerificationKeys)
+		if err != nil {
+			return fmt.Errorf("JWT_VERIFICATION_KEYS: %w", err)
+		}
+		for id, path := range previous {
+			key, err := loadPublicKey(id, path)
+			if err != nil {
+				return err
+			}
+			set.verification[id] = key
+		}
+	default:
+		return fmt.Errorf("unsupported JWT_ALGORITHM %q, expected HS256, RS256 or EdDSA", config.JWTAlgorithm)
+	}
+
+	set.verification[set.current.ID] = set.current
+	keys = set
+	return nil
+}
+
+// parseKeyList parses "kid=value,kid=value" lists.
+func parseKeyList(list string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, value, ok := strings.Cut(entry, "=")
+		if !ok || id == "" || value == "" {
+			return nil, fmt.Errorf("invalid entry %q, expected kid=value", entry)
+		}
+		result[id] = value
+	}
+	return result, nil
+}
+
+func loadPrivateKey(kid, algorithm, path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading signing key: %w", err)
+	}
+
+	if algorithm == "RS256" {
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("parsing RSA signing key: %w", err)
+		}
+		return &signingKey{ID: kid, Method: jwt.SigningMethodRS256, Private: private, Public: &private.PublicKey}, nil
+	}
+
+	parsed, err := jwt.ParseEdPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Ed25519 signing key: %w", err)
+	}
+	private, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("signing key is not an Ed25519 key")
+	}
+	return &signingKey{ID: kid, Method: jwt.SigningMethodEdDSA, Private: private, Public: private.Public()}, nil
+}
+
+func loadPublicKey(kid, path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading verification key %s: %w", kid, err)
+	}
+
+	if public, err := jwt.ParseRSAPublicKeyFromPEM(data); err == nil {
+		return &signingKey{ID: kid, Method: jwt.SigningMethodRS256, Public: public}, nil
+	}
+	if public, err := jwt.ParseEdPublicKeyFromPEM(data); err == nil {
+		return &signingKey{ID: kid, Method: jwt.SigningMethodEdDSA, Public: public}, nil
+	}
+	return nil, fmt.Errorf("verification key %s is neither an RSA nor an Ed25519 public key", kid)
+}
+
+func signClaims(claims jwt.Claims) (string, error) {
+	if keys == nil {
+		return "", errors.New("signing keys are not initialized")
+	}
+
+	token := jwt.NewWithClaims(keys.current.Method, claims)
+	token.Header["kid"] = keys.current.ID
+	return token.SignedString(keys.current.Private)
+}
+
+// verificationKey picks the key for a token based on its "kid" header and
+// makes sure the token was signed with the algorithm that key belongs to.
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	if keys == nil {
+		return nil, errors.New("signing keys are not initialized")
+	}
+
+	key := keys.current
+	if kid, ok := token.Header["kid"].(string); ok {
+		key, ok = keys.verification[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+// PublicJWKS returns the asymmetric verification keys in JWKS form. Shared
+// HS256 secrets are never published.
+func PublicJWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	if keys == nil {
+		return set
+	}
+
+	for _, key := range keys.verification {
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "OKP",
+				Kid: key.ID,
+				Use: "sig",
+				Alg: key.Method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+
+	initializers "github.com/Skapar/NGE/pkg/nge/database/initializers"
+)
+
+// writeKeyPair writes a new key pair of algorithm as PEM files to a
+// temporary directory and returns their paths.
+func writeKeyPair(t *testing.T, algorithm string) (string, string) {
+	t.Helper()
+
+	var private, public interface{}
+	switch algorithm {
+	case "RS256":
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		private, public = key, &key.PublicKey
+	case "EdDSA":
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		private, public = key, pub
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	privatePath := filepath.Join(dir, "private.pem")
+	publicPath := filepath.Join(dir, "public.pem")
+	if err := os.WriteFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return privatePath, publicPath
+}
+
+func initKeys(t *testing.T, config initializers.Config) {
+	t.Helper()
+	previous := keys
+	t.Cleanup(func() { keys = previous })
+	if err := InitSigningKeys(&config); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	rsaKey, _ := writeKeyPair(t, "RS256")
+	edKey, _ := writeKeyPair(t, "EdDSA")
+
+	tests := []struct {
+		name   string
+		config initializers.Config
+	}{
+		{"HS256", initializers.Config{JWTAlgorithm: "HS256", JWTKeyID: "k1", JWTSecret: "secret"}},
+		{"RS256", initializers.Config{JWTAlgorithm: "RS256", JWTKeyID: "k1", JWTPrivateKeyFile: rsaKey}},
+		{"EdDSA", initializers.Config{JWTAlgorithm: "EdDSA", JWTKeyID: "k1", JWTPrivateKeyFile: edKey}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			initKeys(t, tt.config)
+
+			token, err := GenerateToken(7, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			claims, err := ValidateToken(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.UserID != 7 || claims.SessionID != 3 {
+				t.Errorf("claims = %+v, want user 7 and session 3", claims)
+			}
+		})
+	}
+}
+
+func TestVerifyAfterRotation(t *testing.T) {
+	oldKey, oldPublic := writeKeyPair(t, "EdDSA")
+	newKey, _ := writeKeyPair(t, "RS256")
+
+	initKeys(t, initializers.Config{JWTAlgorithm: "EdDSA", JWTKeyID: "old", JWTPrivateKeyFile: oldKey})
+	token, err := GenerateToken(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	initKeys(t, initializers.Config{JWTAlgorithm: "RS256", JWTKeyID: "new", JWTPrivateKeyFile: newKey, JWTVerificationKeys: "old=" + oldPublic})
+	if _, err := ValidateToken(token); err != nil {
+		t.Errorf("token of the retired key was rejected: %v", err)
+	}
+
+	kids := []string{}
+	for _, key := range PublicJWKS().Keys {
+		kids = append(kids, key.Kid)
+	}
+	if len(kids) != 2 || kids[0] != "new" || kids[1] != "old" {
+		t.Errorf("JWKS kids = %v, want [new old]", kids)
+	}
+
+	initKeys(t, initializers.Config{JWTAlgorithm: "RS256", JWTKeyID: "new", JWTPrivateKeyFile: newKey})
+	if _, err := ValidateToken(token); err == nil {
+		t.Error("token of a key that is no longer listed was accepted")
+	}
+}
+
+func TestVerifyRejectsAlgorithmSwitch(t *testing.T) {
+	rsaKey, rsaPublic := writeKeyPair(t, "RS256")
+	initKeys(t, initializers.Config{JWTAlgorithm: "RS256", JWTKeyID: "k1", JWTPrivateKeyFile: rsaKey})
+
+	// A token signed with HS256 using the public key as the secret must not
+	// pass as an RS256 token.
+	publicPEM, err := os.ReadFile(rsaPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &Claims{UserID: 1})
+	token.Header["kid"] = "k1"
+	forged, err := token.SignedString(publicPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateToken(forged); err == nil {
+		t.Error("HS256 token was accepted by an RS256 key")
+	}
+}
+
+func TestPublicJWKSHidesSecrets(t *testing.T) {
+	initKeys(t, initializers.Config{JWTAlgorithm: "HS256", JWTKeyID: "k1", JWTSecret: "secret", JWTPreviousSecrets: "k0=older"})
+	if set := PublicJWKS(); len(set.Keys) != 0 {
+		t.Errorf("JWKS published %d HS256 secrets", len(set.Keys))
+	}
+}
@@ -0,0 +1,163 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/oauth2"
+
+	initializers "github.com/Skapar/NGE/pkg/nge/database/initializers"
+)
+
+var ErrUnknownProvider = errors.New("unknown identity provider")
+
+// httpClient makes the requests to providers. Its transport creates a span
+// for each of them and passes the trace context on in the traceparent header.
+var httpClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
+// Identity is what NGE needs to know about a user signed in at a provider.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Username      string
+}
+
+// Provider is a configured OpenID Connect provider. Discovery happens on
+// first use so an unreachable provider does not keep the server from starting.
+type Provider struct {
+	Name   string
+	config initializers.OIDCProviderConfig
+
+	mu       sync.Mutex
+	provider *gooidc.Provider
+	oauth    *oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+// Registry holds all enabled providers by name.
+type Registry struct {
+	providers map[string]*Provider
+}
+
+func NewRegistry(config *initializers.Config) (*Registry, error) {
+	registry := &Registry{providers: map[string]*Provider{}}
+	for name, providerConfig := range config.OIDCProviders {
+		if providerConfig.Issuer == "" || providerConfig.ClientID == "" || providerConfig.RedirectURL == "" {
+			return nil, fmt.Errorf("OIDC provider %s needs ISSUER, CLIENT_ID and REDIRECT_URL", name)
+		}
+		registry.providers[name] = &Provider{Name: name, config: providerConfig}
+	}
+	return registry, nil
+}
+
+func (r *Registry) Get(name string) (*Provider, error) {
+	provider, ok := r.providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return provider, nil
+}
+
+// Names returns the enabled provider names in alphabetical order.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Provider) discover(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider != nil {
+		return nil
+	}
+
+	provider, err := gooidc.NewProvider(gooidc.ClientContext(ctx, httpClient), p.config.Issuer)
+	if err != nil {
+		return fmt.Errorf("discovering OIDC provider %s: %w", p.Name, err)
+	}
+
+	p.provider = provider
+	p.verifier = provider.Verifier(&gooidc.Config{ClientID: p.config.ClientID})
+	p.oauth = &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       p.config.Scopes,
+	}
+	return nil
+}
+
+// AuthCodeURL returns the URL the user is sent to for signing in, using the
+// authorization code flow with PKCE (S256).
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	if err := p.discover(ctx); err != nil {
+		return "", err
+	}
+	return p.oauth.AuthCodeURL(state, gooidc.Nonce(nonce), oauth2.S256ChallengeOption(codeVerifier)), nil
+}
+
+// Exchange redeems the authorization code, verifies the ID token including
+// its nonce and returns the identity it describes.
+func (p *Provider) Exchange(ctx context.Context, code, nonce, codeVerifier string) (*Identity, error) {
+	if err := p.discover(ctx); err != nil {
+		return nil, err
+	}
+	ctx = gooidc.ClientContext(ctx, httpClient)
+
+	token, err := p.oauth.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchanging authorization code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response did not contain an id_token")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("verifying id_token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("id_token nonce does not match")
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		Name              string `json:"name"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("reading id_token claims: %w", err)
+	}
+
+	return &Identity{
+		Provider:      p.Name,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+		Username:      claims.PreferredUsername,
+	}, nil
+}
+
+// GenerateVerifier returns a new PKCE code verifier.
+func GenerateVerifier() string {
+	return oauth2.GenerateVerifier()
+}
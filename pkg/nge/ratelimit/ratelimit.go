@@ -0,0 +1,184 @@
+// Package ratelimit throttles requests with token buckets. A Policy sets
+// the size of the bucket and how fast it refills; buckets are kept by a
+// Store, in memory by default or in a store shared by several servers.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policy allows Limit requests per Period. Unused requests accumulate up to
+// Limit, so a client may burst after being idle, and the bucket refills
+// continuously at Limit/Period.
+type Policy struct {
+	Limit  int
+	Period time.Duration
+}
+
+// ParsePolicy reads a policy written as LIMIT/PERIOD, such as "10/1m" or
+// "300/1h". An empty string is the zero Policy, which allows everything.
+func ParsePolicy(s string) (Policy, error) {
+	if strings.TrimSpace(s) == "" {
+		return Policy{}, nil
+	}
+	limit, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Policy{}, fmt.Errorf("rate limit %q must look like 10/1m", s)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(limit))
+	if err != nil || n <= 0 {
+		return Policy{}, fmt.Errorf("rate limit %q must allow a positive number of requests", s)
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(period))
+	if err != nil || d <= 0 {
+		return Policy{}, fmt.Errorf("rate limit %q must have a positive period such as 1m", s)
+	}
+	return Policy{Limit: n, Period: d}, nil
+}
+
+// Unlimited reports whether the policy allows every request.
+func (p Policy) Unlimited() bool {
+	return p.Limit <= 0 || p.Period <= 0
+}
+
+func (p Policy) String() string {
+	if p.Unlimited() {
+		return ""
+	}
+	return fmt.Sprintf("%d/%s", p.Limit, p.Period)
+}
+
+// interval is the time it takes to earn one request back.
+func (p Policy) interval() time.Duration {
+	return p.Period / time.Duration(p.Limit)
+}
+
+// Result is the state of a bucket after a request was counted.
+type Result struct {
+	Allowed bool
+	Limit   int
+	// Remaining is the number of requests that can be made right away.
+	Remaining int
+	// RetryAfter is how long to wait before the next request is allowed,
+	// zero when it already is.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Store keeps the buckets. Take counts one request against the bucket of
+// key and reports whether it is allowed; Peek reports the same without
+// counting anything. Implementations must be safe for concurrent use; a
+// store shared by several servers, such as Redis, makes the limits apply to
+// all of them together.
+type Store interface {
+	Take(ctx context.Context, key string, policy Policy) (Result, error)
+	Peek(ctx context.Context, key string, policy Policy) (Result, error)
+}
+
+// Limiter applies a policy per route group, such as "auth" or "write".
+type Limiter struct {
+	Store    Store
+	Policies map[string]Policy
+}
+
+// Take counts a request of the client key to the routes of group. Groups
+// without a policy are not limited, which the zero Limit of the result
+// reports.
+func (l *Limiter) Take(ctx context.Context, group, key string) (Result, error) {
+	policy := l.Policies[group]
+	if policy.Unlimited() {
+		return Result{Allowed: true}, nil
+	}
+	return l.Store.Take(ctx, group+":"+key, policy)
+}
+
+// Peek reports whether the client key could make a request to the routes of
+// group right now, without counting one.
+func (l *Limiter) Peek(ctx context.Context, group, key string) (Result, error) {
+	policy := l.Policies[group]
+	if policy.Unlimited() {
+		return Result{Allowed: true}, nil
+	}
+	return l.Store.Peek(ctx, group+":"+key, policy)
+}
+
+// sweepInterval is how often MemoryStore forgets buckets that refilled.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps the buckets in the memory of the process, so every
+// server applies the limits on its own.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	nextSweep time.Time
+	// now is time.Now, replaced in tests.
+	now func() time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	// full is when the bucket will be full again, after which it is no
+	// different from a missing one.
+	full time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}, now: time.Now}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, policy Policy) (Result, error) {
+	return s.use(key, policy, true), nil
+}
+
+func (s *MemoryStore) Peek(ctx context.Context, key string, policy Policy) (Result, error) {
+	return s.use(key, policy, false), nil
+}
+
+// use refills the bucket of key and, when count is set, takes a token.
+func (s *MemoryStore) use(key string, policy Policy, count bool) Result {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.After(s.nextSweep) {
+		for k, b := range s.buckets {
+			if now.After(b.full) {
+				delete(s.buckets, k)
+			}
+		}
+		s.nextSweep = now.Add(sweepInterval)
+	}
+
+	capacity := float64(policy.Limit)
+	interval := policy.interval()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, updated: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+float64(now.Sub(b.updated))/float64(interval))
+	b.updated = now
+
+	result := Result{Limit: policy.Limit}
+	if b.tokens >= 1 {
+		if count {
+			b.tokens--
+		}
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) * float64(interval))
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((capacity - b.tokens) * float64(interval))
+	b.full = now.Add(result.Reset)
+	return result
+}
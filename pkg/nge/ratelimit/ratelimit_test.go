@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// testStore returns a MemoryStore whose clock only moves when advance is
+// called.
+func testStore() (*MemoryStore, func(time.Duration)) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	return s, func(d time.Duration) { now = now.Add(d) }
+}
+
+func take(t *testing.T, s *MemoryStore, policy Policy) Result {
+	t.Helper()
+	result, err := s.Take(context.Background(), "client", policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestMemoryStoreBurst(t *testing.T) {
+	s, _ := testStore()
+	policy := Policy{Limit: 3, Period: time.Minute}
+
+	for i := 2; i >= 0; i-- {
+		result := take(t, s, policy)
+		if !result.Allowed || result.Remaining != i || result.RetryAfter != 0 {
+			t.Fatalf("request %d = %+v, want allowed with %d remaining", 3-i, result, i)
+		}
+	}
+
+	result := take(t, s, policy)
+	if result.Allowed {
+		t.Fatal("request over the limit was allowed")
+	}
+	if result.RetryAfter != 20*time.Second {
+		t.Errorf("RetryAfter = %s, want 20s", result.RetryAfter)
+	}
+	if result.Reset != time.Minute {
+		t.Errorf("Reset = %s, want 1m", result.Reset)
+	}
+}
+
+func TestMemoryStoreRefill(t *testing.T) {
+	s, advance := testStore()
+	policy := Policy{Limit: 3, Period: time.Minute}
+
+	for i := 0; i < 3; i++ {
+		take(t, s, policy)
+	}
+
+	advance(10 * time.Second)
+	result := take(t, s, policy)
+	if result.Allowed {
+		t.Fatal("request allowed before a token was earned back")
+	}
+	if result.RetryAfter != 10*time.Second {
+		t.Errorf("RetryAfter = %s, want 10s", result.RetryAfter)
+	}
+
+	advance(10 * time.Second)
+	if result := take(t, s, policy); !result.Allowed || result.Remaining != 0 {
+		t.Fatalf("request after one interval = %+v, want allowed with 0 remaining", result)
+	}
+
+	// An idle client gets its burst back, but never more than Limit.
+	advance(time.Hour)
+	if result := take(t, s, policy); !result.Allowed || result.Remaining != 2 {
+		t.Fatalf("request after idling = %+v, want allowed with 2 remaining", result)
+	}
+}
+
+func TestMemoryStoreKeys(t *testing.T) {
+	s, _ := testStore()
+	policy := Policy{Limit: 1, Period: time.Minute}
+
+	ctx := context.Background()
+	if result, _ := s.Take(ctx, "a", policy); !result.Allowed {
+		t.Fatal("first request of a was refused")
+	}
+	if result, _ := s.Take(ctx, "b", policy); !result.Allowed {
+		t.Fatal("b was limited by the requests of a")
+	}
+	if result, _ := s.Take(ctx, "a", policy); result.Allowed {
+		t.Fatal("second request of a was allowed")
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Policy
+		err  bool
+	}{
+		{"", Policy{}, false},
+		{"10/1m", Policy{Limit: 10, Period: time.Minute}, false},
+		{" 300 / 1h ", Policy{Limit: 300, Period: time.Hour}, false},
+		{"10", Policy{}, true},
+		{"0/1m", Policy{}, true},
+		{"10/0s", Policy{}, true},
+		{"ten/1m", Policy{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePolicy(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParsePolicy(%q) = %+v, %v, want %+v, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestMemoryStorePeek(t *testing.T) {
+	s, _ := testStore()
+	policy := Policy{Limit: 1, Period: time.Minute}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if result, _ := s.Peek(ctx, "client", policy); !result.Allowed || result.Remaining != 1 {
+			t.Fatalf("peek %d = %+v, want allowed with 1 remaining", i, result)
+		}
+	}
+
+	take(t, s, policy)
+	result, _ := s.Peek(ctx, "client", policy)
+	if result.Allowed || result.RetryAfter != time.Minute {
+		t.Errorf("peek after the last token = %+v, want refused for 1m", result)
+	}
+}
@@ -0,0 +1,149 @@
+// Package tracing sets up OpenTelemetry tracing: the exporter selected by
+// TRACING_EXPORTER, W3C trace context propagation, and spans for the SQL
+// statements run through gorm.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+
+	initializers "github.com/Skapar/NGE/pkg/nge/database/initializers"
+)
+
+// Name identifies the NGE instrumentation in exported spans.
+const Name = "github.com/Skapar/NGE"
+
+// Tracer returns the tracer for NGE spans.
+func Tracer() trace.Tracer {
+	return otel.Tracer(Name)
+}
+
+// Setup installs the W3C trace context propagator and, unless
+// TRACING_EXPORTER is "none", a tracer provider exporting to OTLP over HTTP
+// or to stdout. The returned function flushes pending spans and must be
+// called on shutdown.
+func Setup(ctx context.Context, cfg *initializers.Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.TracingExporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.TracingOTLPEndpoint))
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unsupported TRACING_EXPORTER %q, expected otlp, stdout or none", cfg.TracingExporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating %s trace exporter: %w", cfg.TracingExporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(cfg.TracingServiceName)))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// Follow the caller's decision when there is one, so that a trace is
+		// either complete or absent.
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// GormPlugin returns a gorm plugin that wraps every SQL statement in a span,
+// child of the span in the statement's context. Queries must be run with
+// db.WithContext to be part of a request's trace.
+func GormPlugin() gorm.Plugin {
+	return gormPlugin{}
+}
+
+type gormPlugin struct{}
+
+const spanKey = "tracing:span"
+
+// registerer is the part of gorm's callback builder the plugin uses.
+type registerer interface {
+	Register(name string, fn func(*gorm.DB)) error
+}
+
+func (gormPlugin) Name() string {
+	return "nge:tracing"
+}
+
+func (gormPlugin) Initialize(db *gorm.DB) error {
+	before := func(operation string) func(db *gorm.DB) {
+		return func(db *gorm.DB) {
+			ctx, span := Tracer().Start(db.Statement.Context, operation,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBOperationName(operation)))
+			db.Statement.Context = ctx
+			db.InstanceSet(spanKey, span)
+		}
+	}
+	after := func(operation string) func(db *gorm.DB) {
+		return func(db *gorm.DB) {
+			value, ok := db.InstanceGet(spanKey)
+			if !ok {
+				return
+			}
+			span := value.(trace.Span)
+			defer span.End()
+
+			// Spans are named like "query companies", the table is only
+			// known once the statement is built.
+			if table := db.Statement.Table; table != "" {
+				span.SetName(operation + " " + table)
+				span.SetAttributes(semconv.DBCollectionName(table))
+			}
+			// The statement has placeholders, so parameter values are not
+			// recorded.
+			span.SetAttributes(semconv.DBQueryText(db.Statement.SQL.String()))
+			if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+				span.RecordError(db.Error)
+				span.SetStatus(codes.Error, db.Error.Error())
+			}
+		}
+	}
+
+	callbacks := db.Callback()
+	for _, hook := range []struct {
+		operation     string
+		before, after registerer
+	}{
+		{"create", callbacks.Create().Before("gorm:create"), callbacks.Create().After("gorm:create")},
+		{"query", callbacks.Query().Before("gorm:query"), callbacks.Query().After("gorm:query")},
+		{"update", callbacks.Update().Before("gorm:update"), callbacks.Update().After("gorm:update")},
+		{"delete", callbacks.Delete().Before("gorm:delete"), callbacks.Delete().After("gorm:delete")},
+		{"row", callbacks.Row().Before("gorm:row"), callbacks.Row().After("gorm:row")},
+		{"raw", callbacks.Raw().Before("gorm:raw"), callbacks.Raw().After("gorm:raw")},
+	} {
+		if err := hook.before.Register("tracing:before_"+hook.operation, before(hook.operation)); err != nil {
+			return err
+		}
+		if err := hook.after.Register("tracing:after_"+hook.operation, after(hook.operation)); err != nil {
+			return err
+		}
+	}
+	return nil
+}